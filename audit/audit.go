@@ -0,0 +1,216 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+const (
+	// TypeFile 审计日志写入 JSON lines 文件
+	TypeFile = "file"
+	// TypeMysql 审计日志写入北极星数据库中的审计表
+	TypeMysql = "mysql"
+
+	defaultPath = "audit/polaris-cleanup-audit.jsonl"
+)
+
+var (
+	journal Journal
+)
+
+// Record 一条删除审计记录
+type Record struct {
+	// ID 审计记录的 ID
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Job       string    `json:"job"`
+	Reason    string    `json:"reason"`
+	RequestID string    `json:"requestId"`
+	Kind      string    `json:"kind"`
+	// ResourceID 被删除资源的 ID
+	ResourceID string                `json:"resourceId"`
+	Namespace  string                `json:"namespace"`
+	Service    string                `json:"service"`
+	Instance   *store.InstanceDetail `json:"instance,omitempty"`
+	// ServiceDetail 被删除服务的完整信息，只有通过数据库删除的服务才有
+	ServiceDetail *store.ServiceDetail `json:"serviceDetail,omitempty"`
+}
+
+// Filter 审计记录的查询条件，空值表示不过滤
+type Filter struct {
+	Since      time.Time
+	Until      time.Time
+	Job        string
+	Namespace  string
+	Service    string
+	ResourceID string
+	ID         string
+	// Limit 只返回满足条件的最新的 Limit 条记录，为 0 时不限制
+	Limit int
+}
+
+// Match 记录是否满足查询条件
+func (f Filter) Match(r *Record) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Time.After(f.Until) {
+		return false
+	}
+	if f.Job != "" && r.Job != f.Job {
+		return false
+	}
+	if f.Namespace != "" && r.Namespace != f.Namespace {
+		return false
+	}
+	if f.Service != "" && r.Service != f.Service {
+		return false
+	}
+	if f.ResourceID != "" && r.ResourceID != f.ResourceID {
+		return false
+	}
+	if f.ID != "" && r.ID != f.ID {
+		return false
+	}
+	return true
+}
+
+// Journal 审计日志，只支持追加写入
+type Journal interface {
+	// Append 追加审计记录
+	Append(records []*Record) error
+	// Query 查询审计记录，按时间顺序返回，设置了 Limit 时返回最新的记录
+	Query(filter Filter) ([]*Record, error)
+	// Close 关闭审计日志
+	Close() error
+}
+
+// Initialize 初始化审计日志，未开启审计时不做任何事情
+func Initialize(cfg common.AppConfig) error {
+	if !cfg.Audit.Enable {
+		return nil
+	}
+	j, err := Open(cfg)
+	if err != nil {
+		return err
+	}
+	journal = j
+	return nil
+}
+
+// Open 根据配置打开审计日志
+func Open(cfg common.AppConfig) (Journal, error) {
+	switch cfg.Audit.Type {
+	case "", TypeFile:
+		path := cfg.Audit.Path
+		if path == "" {
+			path = defaultPath
+		}
+		return newFileJournal(path)
+	case TypeMysql:
+		db, err := store.MysqlDB()
+		if err != nil {
+			return nil, fmt.Errorf("audit type %s is unavailable, %v", TypeMysql, err)
+		}
+		return newMysqlJournal(db)
+	default:
+		return nil, fmt.Errorf("unknown audit type: %s", cfg.Audit.Type)
+	}
+}
+
+// NewRequestID 生成删除请求的 ID，同时用于审计记录
+func NewRequestID(cfg common.AppConfig) string {
+	return cfg.Server.RequestPrefix + uuid.New().String()
+}
+
+// BeforeDelete 删除前记录被删除资源的完整信息，未开启审计时直接返回，记录失败时不应继续删除
+func BeforeDelete(job, requestID string, candidates []common.Candidate) error {
+	if journal == nil || len(candidates) == 0 {
+		return nil
+	}
+
+	var instanceIds, serviceIds []string
+	for _, c := range candidates {
+		if c.ID == "" {
+			continue
+		}
+		switch c.Kind {
+		case common.KindInstance:
+			instanceIds = append(instanceIds, c.ID)
+		case common.KindService:
+			serviceIds = append(serviceIds, c.ID)
+		}
+	}
+
+	instances := map[string]*store.InstanceDetail{}
+	if len(instanceIds) > 0 {
+		details, err := store.GetStore().LoadInstanceDetails(instanceIds)
+		if err != nil {
+			return fmt.Errorf("fail to load instance details for audit, err %v", err)
+		}
+		for _, d := range details {
+			instances[d.ID] = d
+		}
+	}
+	services := map[string]*store.ServiceDetail{}
+	if len(serviceIds) > 0 {
+		details, err := store.GetStore().LoadServiceDetails(serviceIds)
+		if err != nil {
+			return fmt.Errorf("fail to load service details for audit, err %v", err)
+		}
+		for _, d := range details {
+			services[d.ID] = d
+		}
+	}
+
+	now := time.Now()
+	records := make([]*Record, 0, len(candidates))
+	for _, c := range candidates {
+		records = append(records, &Record{
+			ID:            uuid.New().String(),
+			Time:          now,
+			Job:           job,
+			Reason:        c.Reason,
+			RequestID:     requestID,
+			Kind:          c.Kind,
+			ResourceID:    c.ID,
+			Namespace:     c.Namespace,
+			Service:       c.Service,
+			Instance:      instances[c.ID],
+			ServiceDetail: services[c.ID],
+		})
+	}
+	if err := journal.Append(records); err != nil {
+		return fmt.Errorf("fail to append audit records, err %v", err)
+	}
+	return nil
+}
+
+// Close 关闭审计日志
+func Close() error {
+	if journal == nil {
+		return nil
+	}
+	return journal.Close()
+}
@@ -0,0 +1,103 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// fileJournal 以 JSON lines 格式追加写入文件的审计日志
+type fileJournal struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func newFileJournal(path string) (*fileJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileJournal{path: path, file: file}, nil
+}
+
+// Append 追加审计记录，写入后立即落盘
+func (j *fileJournal) Append(records []*Record) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	w := bufio.NewWriter(j.file)
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Query 顺序扫描文件查询审计记录，设置了 Limit 时保留最新的记录
+func (j *fileJournal) Query(filter Filter) ([]*Record, error) {
+	file, err := os.Open(j.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var out []*Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			glog.Warningf("[Audit] skip invalid record at %s:%d, err: %v", j.path, line, err)
+			continue
+		}
+		if !filter.Match(r) {
+			continue
+		}
+		// 文件按写入顺序保存，只保留最新的 Limit 条
+		if filter.Limit > 0 && len(out) == filter.Limit {
+			copy(out, out[1:])
+			out = out[:len(out)-1]
+		}
+		out = append(out, r)
+	}
+	return out, scanner.Err()
+}
+
+// Close 关闭文件
+func (j *fileJournal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.file.Close()
+}
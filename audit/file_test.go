@@ -0,0 +1,73 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package audit
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileJournalQueryLimit(t *testing.T) {
+	j, err := newFileJournal(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var records []*Record
+	for i, id := range []string{"r1", "r2", "r3", "r4", "r5"} {
+		job := "DeleteUnHealthyInstance"
+		if i%2 == 1 {
+			job = "DeleteSoftDeleteInstance"
+		}
+		records = append(records, &Record{ID: id, Job: job, Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	if err := j.Append(records); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "no limit", filter: Filter{}, want: []string{"r1", "r2", "r3", "r4", "r5"}},
+		{name: "newest", filter: Filter{Limit: 2}, want: []string{"r4", "r5"}},
+		{name: "limit over total", filter: Filter{Limit: 10}, want: []string{"r1", "r2", "r3", "r4", "r5"}},
+		{name: "newest matched", filter: Filter{Job: "DeleteUnHealthyInstance", Limit: 2}, want: []string{"r3", "r5"}},
+		{name: "newest until", filter: Filter{Until: start.Add(2 * time.Minute), Limit: 1}, want: []string{"r3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := j.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range out {
+				got = append(got, r.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	createAuditTable = "CREATE TABLE IF NOT EXISTS polaris_cleanup_audit (" +
+		"id VARCHAR(64) NOT NULL, " +
+		"time DATETIME(3) NOT NULL, " +
+		"job VARCHAR(64) NOT NULL, " +
+		"kind VARCHAR(32) NOT NULL, " +
+		"resource_id VARCHAR(128) NOT NULL, " +
+		"namespace VARCHAR(128) NOT NULL, " +
+		"service VARCHAR(128) NOT NULL, " +
+		"request_id VARCHAR(128) NOT NULL, " +
+		"record MEDIUMTEXT NOT NULL, " +
+		"PRIMARY KEY (id), " +
+		"KEY time (time), " +
+		"KEY resource_id (resource_id), " +
+		"KEY service (namespace, service)" +
+		") ENGINE = InnoDB DEFAULT CHARSET = utf8mb4"
+)
+
+// mysqlJournal 写入北极星数据库中 polaris_cleanup_audit 表的审计日志
+type mysqlJournal struct {
+	db *sql.DB
+}
+
+func newMysqlJournal(db *sql.DB) (*mysqlJournal, error) {
+	if _, err := db.Exec(createAuditTable); err != nil {
+		glog.Errorf("[Audit] create audit table err: %s", err.Error())
+		return nil, err
+	}
+	return &mysqlJournal{db: db}, nil
+}
+
+// Append 在一个事务中追加审计记录
+func (j *mysqlJournal) Append(records []*Record) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	str := "insert into polaris_cleanup_audit(id, time, job, kind, resource_id, namespace, service, " +
+		"request_id, record) values(?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(str, r.ID, r.Time.UTC(), r.Job, r.Kind, r.ResourceID, r.Namespace, r.Service,
+			r.RequestID, string(data)); err != nil {
+			glog.Errorf("[Audit] insert audit record err: %s", err.Error())
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Query 查询审计记录
+func (j *mysqlJournal) Query(filter Filter) ([]*Record, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if !filter.Since.IsZero() {
+		conds = append(conds, "time >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conds = append(conds, "time <= ?")
+		args = append(args, filter.Until.UTC())
+	}
+	for column, value := range map[string]string{
+		"job":         filter.Job,
+		"namespace":   filter.Namespace,
+		"service":     filter.Service,
+		"resource_id": filter.ResourceID,
+		"id":          filter.ID,
+	} {
+		if value != "" {
+			conds = append(conds, column+" = ?")
+			args = append(args, value)
+		}
+	}
+
+	str := "select record from polaris_cleanup_audit"
+	if len(conds) > 0 {
+		str += " where " + strings.Join(conds, " and ")
+	}
+	// 设置了 Limit 时倒序取最新的记录，返回前再恢复为时间顺序
+	if filter.Limit > 0 {
+		str += " order by time desc limit ?"
+		args = append(args, filter.Limit)
+	} else {
+		str += " order by time"
+	}
+
+	rows, err := j.db.Query(str, args...)
+	if err != nil {
+		glog.Errorf("[Audit] query audit records err: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Record
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		r := &Record{}
+		if err := json.Unmarshal([]byte(data), r); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if filter.Limit > 0 {
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+	return out, nil
+}
+
+// Close 数据库连接由 store 管理，这里不做任何事情
+func (j *mysqlJournal) Close() error {
+	return nil
+}
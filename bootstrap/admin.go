@@ -0,0 +1,154 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bootstrap
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+// adminServer 管理接口，用于在运行时查看、触发、暂停和恢复任务
+type adminServer struct {
+	cfg common.Admin
+	sc  *common.Scheduler
+}
+
+func newAdminServer(cfg common.Admin, sc *common.Scheduler) *adminServer {
+	return &adminServer{cfg: cfg, sc: sc}
+}
+
+func (s *adminServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/jobs", s.listJobs)
+	mux.HandleFunc("/jobs/", s.operateJob)
+}
+
+// healthz 进程存活即返回成功
+func (s *adminServer) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz 数据库可以访问时返回成功，管理接口在所有任务加入调度后才会启动
+func (s *adminServer) readyz(w http.ResponseWriter, _ *http.Request) {
+	if db := store.GetStore(); db != nil {
+		if err := db.Ping(); err != nil {
+			writeError(w, http.StatusServiceUnavailable, "database is unavailable: "+err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// listJobs GET /jobs
+func (s *adminServer) listJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.sc.Jobs())
+}
+
+// operateJob GET /jobs/{name}，POST /jobs/{name}/run、/jobs/{name}/pause、/jobs/{name}/resume
+func (s *adminServer) operateJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/"), "/")
+	name := parts[0]
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		status, err := s.sc.Job(name)
+		if err != nil {
+			writeSchedulerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var err error
+	switch parts[1] {
+	case "run":
+		err = s.sc.RunJob(name)
+	case "pause":
+		err = s.sc.PauseJob(name)
+	case "resume":
+		err = s.sc.ResumeJob(name)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+	glog.Infof("[Admin] %s job=[%s] from %s", parts[1], name, r.RemoteAddr)
+	status, _ := s.sc.Job(name)
+	writeJSON(w, http.StatusOK, status)
+}
+
+// authorized 未配置 token 时管理接口只监听回环地址，见 common.Admin.Validate
+func (s *adminServer) authorized(r *http.Request) bool {
+	if s.cfg.Token == "" {
+		return true
+	}
+	got := []byte(r.Header.Get("Authorization"))
+	return subtle.ConstantTimeCompare(got, []byte("Bearer "+s.cfg.Token)) == 1
+}
+
+func writeSchedulerError(w http.ResponseWriter, err error) {
+	switch err {
+	case common.ErrJobNotFound:
+		writeError(w, http.StatusNotFound, err.Error())
+	case common.ErrJobRunning, common.ErrNotLeader:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, info string) {
+	writeJSON(w, code, map[string]string{"error": info})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		glog.Errorf("[Admin] write response err: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bootstrap
+
+import (
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/audit"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/restore"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+// QueryAudit 按条件查询审计记录
+func QueryAudit(filePath string, filter audit.Filter) ([]*audit.Record, error) {
+	appConfig, err := common.LoadConfig(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if appConfig.Audit.Type == audit.TypeMysql {
+		if err := store.Initialize(*appConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	journal, err := audit.Open(*appConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer journal.Close()
+
+	return journal.Query(filter)
+}
+
+// RestoreInstances 按条件从审计记录中恢复被删除的实例，返回每个实例的恢复结果
+func RestoreInstances(filePath string, filter audit.Filter) ([]restore.Result, error) {
+	if filter.Job == "" {
+		filter.Job = restore.DefaultJob
+	}
+	appConfig, err := common.LoadConfig(filePath)
+	if err != nil {
+		return nil, err
+	}
+	records, err := QueryAudit(filePath, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := restore.SelectInstances(records)
+	glog.Infof("[Restore] %d audit records, %d instances to restore", len(records), len(instances))
+	return restore.Instances(*appConfig, instances), nil
+}
@@ -0,0 +1,500 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/polarismesh/polaris-cleanup/audit"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/election"
+	"github.com/polarismesh/polaris-cleanup/guard"
+	"github.com/polarismesh/polaris-cleanup/job"
+	"github.com/polarismesh/polaris-cleanup/polaris"
+	"github.com/polarismesh/polaris-cleanup/store"
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+
+	doctorTimeout = 5 * time.Second
+)
+
+// 需要删除实例或者删除服务权限的任务
+var (
+	deleteInstanceJobs = []string{"DeleteUnHealthyInstance", "DeleteK8sInvalidInstance"}
+	deleteServiceJobs  = []string{"DeleteEmptyService"}
+)
+
+// doctor 启动前的体检，逐项输出检查结果
+type doctor struct {
+	cfg    *common.AppConfig
+	client *polaris.Client
+	failed int
+}
+
+func (d *doctor) report(level, item, format string, args ...interface{}) {
+	if level == checkFail {
+		d.failed++
+	}
+	fmt.Printf("[%s] %s: %s\n", level, item, fmt.Sprintf(format, args...))
+}
+
+// Doctor 检查配置、数据库表结构、北极星接口的连通性以及 token 的权限，任意一项失败时返回错误
+func Doctor(filePath string) error {
+	d := &doctor{}
+	d.checkUnknownKeys(filePath)
+
+	cfg, err := common.LoadConfig(filePath)
+	if err != nil {
+		d.report(checkFail, "config", "%v", err)
+		return fmt.Errorf("%d checks failed", d.failed)
+	}
+	d.cfg = cfg
+	d.client = polaris.NewClient(cfg.Server.HTTP())
+	d.report(checkPass, "config", "loaded from %s", filePath)
+
+	d.checkConfig()
+	if d.checkStore() {
+		d.checkAudit()
+	}
+	defer store.Close()
+	d.checkEndpoints()
+	d.checkKubernetes()
+
+	if d.failed > 0 {
+		return fmt.Errorf("%d checks failed", d.failed)
+	}
+	return nil
+}
+
+// checkUnknownKeys 检查配置文件中拼写错误或者不支持的字段，如 cleanup 应为 cleanUp
+func (d *doctor) checkUnknownKeys(filePath string) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+	if err := yaml.UnmarshalStrict(data, &common.AppConfig{}); err != nil {
+		d.report(checkFail, "config keys", "%v", err)
+		return
+	}
+	d.report(checkPass, "config keys", "no unknown key")
+}
+
+func (d *doctor) checkConfig() {
+	cfg := d.cfg
+	var missing []string
+	for name, empty := range map[string]bool{
+		"store.dbHost": cfg.Store.DbHost == "",
+		"store.dbPort": cfg.Store.DbPort <= 0,
+		"store.dbName": cfg.Store.DbName == "",
+		"store.dbUser": cfg.Store.DbUser == "",
+	} {
+		if empty && !cfg.Store.IsBoltDB() {
+			missing = append(missing, name)
+		}
+	}
+	if len(cfg.Server.Endpoints) == 0 {
+		missing = append(missing, "server.endpoints")
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		d.report(checkFail, "required fields", "%s not set", strings.Join(missing, ", "))
+	} else {
+		d.report(checkPass, "required fields", "store and server are set")
+	}
+
+	d.checkEnum("leaderElection.type", cfg.LeaderElection.Type, election.TypeMysql, election.TypeKubernetes)
+	d.checkEnum("audit.type", cfg.Audit.Type, audit.TypeFile, audit.TypeMysql)
+	d.checkEnum("guard.action", cfg.Guard.Action, guard.ActionAbort, guard.ActionHold)
+	if cfg.Store.IsBoltDB() && cfg.LeaderElection.Enable &&
+		(cfg.LeaderElection.Type == "" || cfg.LeaderElection.Type == election.TypeMysql) {
+		d.report(checkFail, "leaderElection", "type %s is not supported when store.type is %s",
+			election.TypeMysql, common.StoreTypeBoltDB)
+	}
+	if cfg.Guard.MaxDeletePercent > 100 || cfg.Guard.ServiceMaxDeletePercent > 100 {
+		d.report(checkWarn, "guard", "a percentage over 100 never trips the guard")
+	}
+
+	jobs := cfg.EnabledJobs()
+	if len(jobs) == 0 {
+		d.report(checkWarn, "openJob", "no job is enabled")
+	}
+	for _, name := range jobs {
+		d.checkJob(name)
+	}
+}
+
+func (d *doctor) checkEnum(item, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, v := range allowed {
+		if value == v {
+			d.report(checkPass, item, "%s", value)
+			return
+		}
+	}
+	d.report(checkFail, item, "unknown value %s, should be one of %s", value, strings.Join(allowed, ", "))
+}
+
+// checkJob 检查任务是否存在、cron 表达式以及清理参数
+func (d *doctor) checkJob(name string) {
+	item := "job " + name
+	task, ok := job.NewJob(name)
+	if !ok {
+		d.report(checkFail, item, "job not found")
+		return
+	}
+	jobCfg := d.cfg.ForJob(name)
+	task.Init(jobCfg)
+	if err := common.ValidateCronSpec(task.CronSpec()); err != nil {
+		d.report(checkFail, item, "invalid cron %s, %v", task.CronSpec(), err)
+		return
+	}
+
+	cleanups := []common.Cleanup{jobCfg.Cleanup}
+	if name == "DeleteSoftDeleteRules" {
+		kinds := map[string]bool{}
+		for _, kind := range store.RuleKinds() {
+			kinds[kind] = true
+		}
+		for _, rule := range d.cfg.GetJobConfig(name).Rules {
+			if !kinds[rule.Kind] {
+				d.report(checkFail, item, "unknown rule kind %s", rule.Kind)
+				return
+			}
+			cleanups = append(cleanups, rule.Cleanup.Merge(jobCfg.Cleanup))
+		}
+	}
+	if name == "DeleteK8sInvalidInstance" && len(d.cfg.Kubernetes.Namespaces) == 0 {
+		d.report(checkFail, item, "kubernetes.namespaces is not set")
+		return
+	}
+	for _, c := range cleanups {
+		if c.LimitedTime < 0 || c.LimitedNum < 0 || c.BatchDeleteNum < 0 || c.PageSize < 0 || c.MaxRunTime < 0 ||
+			c.Concurrency < 0 || c.DeleteRate < 0 || c.SlowBatchTime < 0 {
+			d.report(checkFail, item, "negative cleanup parameters, check deleteLimitedTime, deleteLimitedNum, "+
+				"batchDeleteNum, pageSize, maxRunTime, concurrency, deleteRate and slowBatchTime")
+			return
+		}
+		// 空服务任务不通过数据库查询，不受 deleteLimitedNum 影响
+		if c.LimitedNum == 0 && name != "DeleteEmptyService" {
+			d.report(checkWarn, item, "deleteLimitedNum is 0, nothing will be cleaned")
+			return
+		}
+		if c.LimitedTime == 0 && name != "DeleteEmptyService" {
+			d.report(checkWarn, item, "deleteLimitedTime is 0, resources are cleaned without any grace period")
+			return
+		}
+	}
+	d.report(checkPass, item, "cron %s", task.CronSpec())
+}
+
+// checkStore 检查存储的连通性，连接成功时返回 true
+func (d *doctor) checkStore() bool {
+	if d.cfg.Store.IsBoltDB() {
+		return d.checkBoltDB()
+	}
+	return d.checkMysql()
+}
+
+// checkBoltDB 检查北极星单机版数据文件是否可以打开，北极星运行时数据文件被锁住，打开会失败
+func (d *doctor) checkBoltDB() bool {
+	if err := store.Initialize(*d.cfg); err != nil {
+		d.report(checkFail, "boltdb", "open %s fail, %v", d.cfg.Store.Path, err)
+		return false
+	}
+	counts, err := store.GetStore().CountServiceInstances()
+	if err != nil {
+		d.report(checkFail, "boltdb", "read %s fail, %v", d.cfg.Store.Path, err)
+		return true
+	}
+	d.report(checkPass, "boltdb", "opened %s, %d services have instances", d.cfg.Store.Path, len(counts))
+	return true
+}
+
+// checkMysql 检查数据库的连通性以及依赖的表和字段，连接成功时返回 true
+func (d *doctor) checkMysql() bool {
+	address := fmt.Sprintf("%s:%d/%s", d.cfg.Store.DbHost, d.cfg.Store.DbPort, d.cfg.Store.DbName)
+	if err := store.Initialize(*d.cfg); err != nil {
+		d.report(checkFail, "mysql", "connect %s fail, %v", address, err)
+		return false
+	}
+	d.report(checkPass, "mysql", "connected to %s", address)
+	d.checkReplicas()
+
+	var ruleKinds []string
+	for _, name := range d.cfg.EnabledJobs() {
+		if name != "DeleteSoftDeleteRules" {
+			continue
+		}
+		for _, rule := range d.cfg.GetJobConfig(name).Rules {
+			ruleKinds = append(ruleKinds, rule.Kind)
+		}
+		// 未配置时任务只清理有数据表的规则类型
+		if len(ruleKinds) == 0 {
+			kinds, err := store.GetStore().ExistingRuleKinds()
+			if err != nil {
+				d.report(checkFail, "mysql schema", "load tables fail, %v", err)
+				return true
+			}
+			ruleKinds = kinds
+			d.report(checkPass, "rule kinds", "DeleteSoftDeleteRules cleans %s", strings.Join(kinds, ", "))
+		}
+	}
+
+	columns, err := store.GetStore().(*store.PolarisDB).LoadColumns()
+	if err != nil {
+		d.report(checkFail, "mysql schema", "load columns fail, %v", err)
+		return true
+	}
+	required := store.RequiredColumns(ruleKinds)
+	tables := make([]string, 0, len(required))
+	for table := range required {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		exist, ok := columns[table]
+		if !ok {
+			d.report(checkFail, "mysql table "+table, "table not found")
+			continue
+		}
+		var missing []string
+		for _, column := range required[table] {
+			i := sort.SearchStrings(exist, column)
+			if i == len(exist) || exist[i] != column {
+				missing = append(missing, column)
+			}
+		}
+		if len(missing) > 0 {
+			d.report(checkFail, "mysql table "+table, "columns not found: %s", strings.Join(missing, ", "))
+			continue
+		}
+		d.report(checkPass, "mysql table "+table, "%d columns", len(required[table]))
+	}
+	return true
+}
+
+// checkReplicas 检查每个只读副本的连通性，连接串中的密码不会输出
+func (d *doctor) checkReplicas() {
+	for i, source := range d.cfg.Store.Replicas {
+		item := fmt.Sprintf("mysql replica %d", i)
+		dsn, err := mysql.ParseDSN(source)
+		if err != nil {
+			d.report(checkFail, item, "invalid dsn, %v", err)
+			continue
+		}
+		address := fmt.Sprintf("%s/%s", dsn.Addr, dsn.DBName)
+		db, err := store.NewMysqlDB(source)
+		if err != nil {
+			d.report(checkFail, item, "connect %s fail, %v", address, err)
+			continue
+		}
+		_ = db.Close()
+		d.report(checkPass, item, "connected to %s", address)
+	}
+}
+
+func (d *doctor) checkAudit() {
+	if !d.cfg.Audit.Enable {
+		return
+	}
+	j, err := audit.Open(*d.cfg)
+	if err != nil {
+		d.report(checkFail, "audit", "open journal fail, %v", err)
+		return
+	}
+	_ = j.Close()
+	d.report(checkPass, "audit", "journal is writable")
+}
+
+// checkEndpoints 检查每个北极星服务端的连通性，以及 token 是否有开启的任务需要的查询和删除权限
+func (d *doctor) checkEndpoints() {
+	needInstances := d.anyEnabled(deleteInstanceJobs)
+	needServices := d.anyEnabled(deleteServiceJobs)
+	if d.cfg.Server.Protocol == common.ProtocolGRPC {
+		d.checkGRPCEndpoints(needInstances)
+		// 删除实例通过 gRPC 接入点，HTTP 接入点只用于删除服务和恢复实例
+		needInstances = false
+		if needServices && len(d.cfg.Server.HTTPEndpoints) == 0 {
+			d.report(checkFail, "server.httpEndpoints", "deleting services is not supported by grpc, httpEndpoints should be set")
+		}
+	}
+	for _, endpoint := range d.cfg.Server.HTTP().Endpoints {
+		item := "endpoint " + endpoint
+		code, info, err := d.callPolaris(polaris.Request{
+			Method:   http.MethodGet,
+			Path:     "/naming/v1/services",
+			Query:    url.Values{"limit": []string{"1"}},
+			Endpoint: endpoint,
+		})
+		if err != nil {
+			d.report(checkFail, item, "unreachable, %v", err)
+			continue
+		}
+		if code != 200000 {
+			d.report(checkFail, item, "fail to list services, code: %d, info: %s", code, info)
+			continue
+		}
+		d.report(checkPass, item, "reachable, token can list services")
+
+		// 删除一个不存在的资源来验证删除权限，不会影响任何已有的资源
+		notExist := "polaris-cleanup-doctor-" + uuid.New().String()
+		if needInstances {
+			body := []map[string]string{{"id": notExist}}
+			d.checkDeletePermission(item, endpoint, "/naming/v1/instances/delete", "delete instances", body)
+		}
+		if needServices {
+			body := []map[string]string{{"name": notExist, "namespace": "default"}}
+			d.checkDeletePermission(item, endpoint, "/naming/v1/services/delete", "delete services", body)
+		}
+	}
+}
+
+// checkGRPCEndpoints 查询一个不存在的服务的实例来检查 gRPC 接入点的连通性，反注册一个不存在的实例来检查删除权限
+func (d *doctor) checkGRPCEndpoints(needInstances bool) {
+	for _, endpoint := range d.cfg.Server.Endpoints {
+		item := "grpc endpoint " + endpoint
+		server := d.cfg.Server
+		server.Endpoints = []string{endpoint}
+		server.MaxRetries = -1
+		client := polaris.NewGRPCClient(server)
+
+		notExist := "polaris-cleanup-doctor-" + uuid.New().String()
+		_, err := client.GetInstances("default", notExist)
+		if err != nil && polaris.CodeOf(err) == 0 {
+			d.report(checkFail, item, "unreachable, %v", err)
+			_ = client.Close()
+			continue
+		}
+		if code := polaris.CodeOf(err); code/1000 == 401 {
+			d.report(checkFail, item, "token is not allowed to discover instances, %v", err)
+		} else {
+			d.report(checkPass, item, "reachable, token can discover instances")
+		}
+
+		if needInstances {
+			err := client.DeleteInstances("", "", []string{notExist})
+			code := polaris.CodeOf(err)
+			switch {
+			case err != nil && code == 0:
+				d.report(checkFail, item, "fail to deregister instances, %v", err)
+			case code/1000 == 401:
+				d.report(checkFail, item, "token is not allowed to deregister instances, %v", err)
+			default:
+				d.report(checkPass, item, "token can deregister instances")
+			}
+		}
+		_ = client.Close()
+	}
+}
+
+func (d *doctor) checkDeletePermission(item, endpoint, path, action string, body interface{}) {
+	code, info, err := d.callPolaris(polaris.Request{
+		Method:   http.MethodPost,
+		Path:     path,
+		Body:     body,
+		Endpoint: endpoint,
+	})
+	if err != nil {
+		d.report(checkFail, item, "fail to %s, %v", action, err)
+		return
+	}
+	// 401xxx 为鉴权失败，其他错误码（如资源不存在）说明已经通过了鉴权
+	if code/1000 == 401 {
+		d.report(checkFail, item, "token is not allowed to %s, code: %d, info: %s", action, code, info)
+		return
+	}
+	d.report(checkPass, item, "token can %s", action)
+}
+
+// callPolaris 调用指定接入点的北极星接口并返回回复中的错误码，批量接口返回第一个子请求的错误码
+func (d *doctor) callPolaris(req polaris.Request) (int, string, error) {
+	var rsp struct {
+		Code      int    `json:"code"`
+		Info      string `json:"info"`
+		Responses []struct {
+			Code int    `json:"code"`
+			Info string `json:"info"`
+		} `json:"responses"`
+	}
+	err := d.client.Do(req, &rsp)
+	if len(rsp.Responses) > 0 && rsp.Responses[0].Code != 0 {
+		return rsp.Responses[0].Code, rsp.Responses[0].Info, nil
+	}
+	if perr, ok := err.(*polaris.Error); ok {
+		return perr.Code, perr.Info, nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return rsp.Code, rsp.Info, nil
+}
+
+// checkKubernetes 开启 DeleteK8sInvalidInstance 时检查是否可以查询映射的命名空间中的 pod
+func (d *doctor) checkKubernetes() {
+	if !d.anyEnabled([]string{"DeleteK8sInvalidInstance"}) {
+		return
+	}
+	restCfg, err := clientcmd.BuildConfigFromFlags("", d.cfg.Kubernetes.KubeConfig)
+	if err != nil {
+		d.report(checkFail, "kubernetes", "fail to build config, %v", err)
+		return
+	}
+	restCfg.Timeout = doctorTimeout
+	client, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		d.report(checkFail, "kubernetes", "fail to create client, %v", err)
+		return
+	}
+	for _, mapping := range d.cfg.Kubernetes.Namespaces {
+		item := "kubernetes namespace " + mapping.Kubernetes
+		if _, err := client.CoreV1().Pods(mapping.Kubernetes).List(metav1.ListOptions{Limit: 1}); err != nil {
+			d.report(checkFail, item, "fail to list pods, %v", err)
+			continue
+		}
+		d.report(checkPass, item, "pods can be listed")
+	}
+}
+
+func (d *doctor) anyEnabled(names []string) bool {
+	for _, enabled := range d.cfg.EnabledJobs() {
+		for _, name := range names {
+			if enabled == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bootstrap
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/metrics"
+)
+
+const (
+	defaultHTTPListen = "0.0.0.0:9090"
+)
+
+// startHTTPServers 启动监控指标和管理接口的 HTTP 服务，监听地址相同时共用一个服务，
+// 返回的函数用于退出时关闭服务
+func startHTTPServers(cfg common.AppConfig, sc *common.Scheduler) (func(), error) {
+	muxes := map[string]*http.ServeMux{}
+	getMux := func(listen string) *http.ServeMux {
+		if listen == "" {
+			listen = defaultHTTPListen
+		}
+		if _, ok := muxes[listen]; !ok {
+			muxes[listen] = http.NewServeMux()
+		}
+		return muxes[listen]
+	}
+	if cfg.Metrics.Enable {
+		getMux(cfg.Metrics.Listen).Handle("/metrics", metrics.Handler())
+	}
+	if cfg.Admin.Enable {
+		newAdminServer(cfg.Admin, sc).register(getMux(cfg.Admin.Listen))
+	}
+
+	var stops []func()
+	stopAll := func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	for listen, mux := range muxes {
+		stop, err := startHTTPServer(listen, mux)
+		if err != nil {
+			stopAll()
+			return nil, err
+		}
+		stops = append(stops, stop)
+	}
+	return stopAll, nil
+}
+
+// startHTTPServer 监听地址并在后台提供 HTTP 服务
+func startHTTPServer(listen string, handler http.Handler) (func(), error) {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: handler}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("http server on %s stopped, err: %v", listen, err)
+		}
+	}()
+	glog.Infof("http server listen on %s", listen)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}, nil
+}
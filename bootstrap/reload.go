@@ -0,0 +1,226 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/job"
+)
+
+const (
+	defaultWatchInterval = 10 * time.Second
+)
+
+// daemon start 命令运行中的任务，用于重新加载配置
+type daemon struct {
+	filePath string
+	dryRun   bool
+	sc       *common.Scheduler
+
+	lock  sync.Mutex
+	cfg   *common.AppConfig
+	tasks map[string]job.PolarisCleanJob
+}
+
+func newDaemon(filePath string, dryRun bool, sc *common.Scheduler) *daemon {
+	return &daemon{
+		filePath: filePath,
+		dryRun:   dryRun,
+		sc:       sc,
+		tasks:    map[string]job.PolarisCleanJob{},
+	}
+}
+
+// apply 按照配置调整调度中的任务，新增的任务加入调度，配置有变化的任务重新 Init 后替换，
+// 不再开启的任务移出调度；任何任务的配置无效时不做任何修改
+func (d *daemon) apply(cfg common.AppConfig) error {
+	wanted := map[string]job.PolarisCleanJob{}
+	for _, name := range cfg.EnabledJobs() {
+		if old, ok := d.tasks[name]; ok && d.cfg != nil && reflect.DeepEqual(jobView(*d.cfg, name),
+			jobView(cfg, name)) {
+			wanted[name] = old
+			continue
+		}
+		task, ok := job.NewJob(name)
+		if !ok {
+			return fmt.Errorf("job=[%s] not found", name)
+		}
+		task.Init(cfg.ForJob(name))
+		if err := common.ValidateCronSpec(task.CronSpec()); err != nil {
+			return fmt.Errorf("invalid cron=[%s] of job=[%s], %v", task.CronSpec(), name, err)
+		}
+		wanted[name] = task
+	}
+
+	for name, task := range d.tasks {
+		if _, ok := wanted[name]; ok {
+			continue
+		}
+		if err := d.sc.RemoveJob(name, d.release(task)); err != nil {
+			glog.Errorf("remove job=[%s] fail %+v", name, err)
+			d.destroy(task)
+		}
+		glog.Infof("stop job=[%s]", name)
+	}
+	for name, task := range wanted {
+		old, exist := d.tasks[name]
+		if exist && old == task {
+			continue
+		}
+		if _, err := d.sc.AddJob(task); err != nil {
+			return fmt.Errorf("add job=[%s] fail %+v", name, err)
+		}
+		if exist {
+			if err := d.sc.AfterRun(name, d.release(old)); err != nil {
+				glog.Errorf("release job=[%s] fail %+v", name, err)
+				d.destroy(old)
+			}
+			glog.Infof("reload job=[%s], cron=[%s]", name, task.CronSpec())
+		} else {
+			glog.Infof("start job=[%s], cron=[%s]", name, task.CronSpec())
+		}
+		d.tasks[name] = task
+	}
+	for name := range d.tasks {
+		if _, ok := wanted[name]; !ok {
+			delete(d.tasks, name)
+		}
+	}
+	d.cfg = &cfg
+	return nil
+}
+
+// jobView 任务可见的配置，只保留该任务自己的 openJob 配置，避免其他任务的修改导致该任务重新加载
+func jobView(cfg common.AppConfig, name string) common.AppConfig {
+	view := cfg.ForJob(name)
+	view.OpenJob = []common.JobConfig{cfg.GetJobConfig(name)}
+	return view
+}
+
+// release 返回释放旧任务的回调，旧任务正在执行时由调度器在执行结束后调用，避免关闭执行中用到的客户端
+func (d *daemon) release(task job.PolarisCleanJob) func() {
+	return func() {
+		d.destroy(task)
+		glog.Infof("destroy job=[%s]", task.Name())
+	}
+}
+
+func (d *daemon) destroy(task job.PolarisCleanJob) {
+	if err := task.Destory(); err != nil {
+		glog.Errorf("destroy job=[%s] fail %+v", task.Name(), err)
+	}
+}
+
+// reload 重新加载配置文件，新的配置无效时继续使用原来的配置
+func (d *daemon) reload() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	cfg, err := common.LoadConfig(d.filePath)
+	if err != nil {
+		glog.Errorf("[Reload] load config fail, keep the old config, err: %v", err)
+		return
+	}
+	if d.dryRun {
+		cfg.DryRun = true
+	}
+	diff, err := common.DiffConfig(*d.cfg, *cfg)
+	if err != nil {
+		glog.Errorf("[Reload] diff config fail, keep the old config, err: %v", err)
+		return
+	}
+	if len(diff) == 0 {
+		glog.Info("[Reload] config is not changed")
+		return
+	}
+
+	old := *d.cfg
+	if err := d.apply(*cfg); err != nil {
+		glog.Errorf("[Reload] apply config fail, keep the old config, err: %v", err)
+		return
+	}
+	for _, line := range diff {
+		glog.Infof("[Reload] %s", line)
+	}
+	warnRestartRequired(old, *cfg)
+	glog.Info("[Reload] config reloaded")
+}
+
+// warnRestartRequired 启动时才会使用的配置不会热加载，修改后提示需要重启
+func warnRestartRequired(old, cfg common.AppConfig) {
+	sections := map[string][2]interface{}{
+		"store":          {old.Store, cfg.Store},
+		"leaderElection": {old.LeaderElection, cfg.LeaderElection},
+		"audit":          {old.Audit, cfg.Audit},
+		"metrics":        {old.Metrics, cfg.Metrics},
+		"admin":          {old.Admin, cfg.Admin},
+		"reload":         {old.Reload, cfg.Reload},
+	}
+	for name, values := range sections {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			glog.Warningf("[Reload] changes of %s take effect after restart", name)
+		}
+	}
+}
+
+// watch 定时检查配置文件的修改时间和大小，发生变化时重新加载配置，返回的函数用于停止检查
+func (d *daemon) watch(cfg common.Reload) func() {
+	if !cfg.Watch {
+		return func() {}
+	}
+	interval := defaultWatchInterval
+	if cfg.Interval > 0 {
+		interval = time.Duration(cfg.Interval) * time.Second
+	}
+
+	last, _ := os.Stat(d.filePath)
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(d.filePath)
+				if err != nil {
+					glog.Errorf("[Reload] stat config file fail, err: %v", err)
+					continue
+				}
+				if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+					continue
+				}
+				last = info
+				glog.Infof("[Reload] config file %s changed", d.filePath)
+				d.reload()
+			}
+		}
+	}()
+	glog.Infof("[Reload] watch config file %s every %v", d.filePath, interval)
+	return func() {
+		close(stop)
+	}
+}
@@ -28,16 +28,21 @@ import (
 
 var darwinSignals = []os.Signal{
 	syscall.SIGINT, syscall.SIGTERM,
-	syscall.SIGSEGV, syscall.SIGUSR1,
+	syscall.SIGSEGV, syscall.SIGUSR1, syscall.SIGHUP,
 }
 
-// RunMainLoop server主循环
-func RunMainLoop(sc *common.Scheduler) {
+// RunMainLoop server主循环，收到 SIGHUP 时重新加载配置
+func RunMainLoop(sc *common.Scheduler, reload func()) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, darwinSignals...)
 	for {
 		select {
 		case s := <-ch:
+			if s == syscall.SIGHUP {
+				glog.Infof("catch signal(%+v), reload config", s)
+				reload()
+				continue
+			}
 			sc.Stop()
 			glog.Infof("catch signal(%+v), stop servers", s)
 			return
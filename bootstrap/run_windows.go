@@ -31,8 +31,8 @@ var winSignals = []os.Signal{
 	syscall.SIGSEGV,
 }
 
-// RunMainLoop server主循环
-func RunMainLoop(sc *common.Scheduler) {
+// RunMainLoop server主循环，windows 下没有 SIGHUP，只能通过监听配置文件重新加载配置
+func RunMainLoop(sc *common.Scheduler, _ func()) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, winSignals...)
 	for {
@@ -18,42 +18,153 @@
 package bootstrap
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/audit"
 	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/election"
 	"github.com/polarismesh/polaris-cleanup/job"
+	"github.com/polarismesh/polaris-cleanup/store"
 )
 
-func Run(filePath string) error {
-
-	// 从环境变量中获取配置
-	appConfig, err := common.LoadConfig(filePath)
+func Run(filePath string, dryRun bool) error {
+	appConfig, err := loadConfig(filePath, dryRun)
 	if err != nil {
 		return err
 	}
 
-	glog.Infof("get config %v", appConfig)
-
 	sc := common.NewDefaultScheduler()
+	stopElection, err := startElection(*appConfig, sc)
+	if err != nil {
+		return err
+	}
+	defer stopElection()
 	sc.Start()
 
-	jobs := job.GetAllRegister()
-	openJobs := appConfig.OpenJob
+	d := newDaemon(filePath, dryRun, sc)
+	if err := d.apply(*appConfig); err != nil {
+		return err
+	}
+
+	stopHTTP, err := startHTTPServers(*appConfig, sc)
+	if err != nil {
+		return fmt.Errorf("start http server fail %+v", err)
+	}
+	defer stopHTTP()
 
-	for i := range openJobs {
-		task, ok := jobs[openJobs[i]]
+	stopWatch := d.watch(appConfig.Reload)
+	defer stopWatch()
+
+	RunMainLoop(sc, d.reload)
+	_ = audit.Close()
+	store.Close()
+	return nil
+}
+
+// startElection 开启选主后只有 leader 执行任务，返回的函数用于退出时释放 leader 身份
+func startElection(cfg common.AppConfig, sc *common.Scheduler) (func(), error) {
+	if !cfg.LeaderElection.Enable {
+		return func() {}, nil
+	}
+	elector, err := election.NewElector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create leader elector fail %+v", err)
+	}
+	sc.SetLeaderChecker(elector.IsLeader)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		elector.Run(ctx)
+	}()
+	glog.Infof("leader election is on, identity=[%s]", election.Identity(cfg))
+
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+// RunOnce 按顺序立即执行一次指定的任务，未指定任务时执行 openJob 中开启的任务，force 为 true 时跳过批量删除保护
+func RunOnce(filePath string, names []string, dryRun, force bool) error {
+	appConfig, err := loadConfig(filePath, dryRun)
+	if err != nil {
+		return err
+	}
+	appConfig.Guard.Force = force
+
+	if len(names) == 0 {
+		names = appConfig.EnabledJobs()
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no job to run")
+	}
+
+	jobs := job.GetAllRegister()
+	tasks := make([]job.PolarisCleanJob, 0, len(names))
+	for _, name := range names {
+		task, ok := jobs[name]
 		if !ok {
-			panic("JOB NOT FOUND - " + openJobs[i])
+			return fmt.Errorf("job=[%s] not found", name)
 		}
+		tasks = append(tasks, task)
+	}
 
-		task.Init(*appConfig)
-		if _, err = sc.AddJob(task); err != nil {
-			return fmt.Errorf("add job=[%s] fail %+v", task.Name(), err)
+	failed := 0
+	for _, task := range tasks {
+		task.Init(appConfig.ForJob(task.Name()))
+		start := time.Now()
+		report, err := task.Run()
+		cost := time.Since(start)
+		if err != nil {
+			failed++
+			glog.Errorf("run job=[%s] fail %+v", task.Name(), err)
+			fmt.Printf("[FAIL] job=[%s] cost: %v, err: %v\n", task.Name(), cost, err)
+		} else {
+			fmt.Printf("[OK] %s, cost: %v\n", report.Summary(), cost)
+		}
+		if err := task.Destory(); err != nil {
+			glog.Errorf("destroy job=[%s] fail %+v", task.Name(), err)
 		}
-		glog.Infof("start job=[%s]", task.Name())
 	}
 
-	RunMainLoop(sc)
+	_ = audit.Close()
+	store.Close()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(tasks))
+	}
 	return nil
 }
+
+// loadConfig 加载配置，并初始化存储层和审计日志
+func loadConfig(filePath string, dryRun bool) (*common.AppConfig, error) {
+	// 从配置文件和环境变量中获取配置
+	appConfig, err := common.LoadConfig(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		appConfig.DryRun = true
+	}
+
+	resolved, err := appConfig.Redacted()
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("get config\n%s", resolved)
+	if appConfig.DryRun {
+		glog.Infof("dry-run mode is on, no resource will be deleted")
+	}
+
+	if err := store.Initialize(*appConfig); err != nil {
+		return nil, err
+	}
+	if err := audit.Initialize(*appConfig); err != nil {
+		return nil, fmt.Errorf("init audit journal fail %+v", err)
+	}
+	return appConfig, nil
+}
@@ -0,0 +1,97 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/polarismesh/polaris-cleanup/audit"
+	"github.com/polarismesh/polaris-cleanup/bootstrap"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditSince  string
+	auditUntil  string
+	auditFilter audit.Filter
+
+	auditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "query the deletion audit journal",
+		Long:  "this command queries the deletion audit journal and prints the records as JSON lines",
+		Run: func(_ *cobra.Command, _ []string) {
+			filter, err := buildAuditFilter()
+			if err != nil {
+				log.Fatal("invalid audit filter. ", err)
+			}
+			records, err := bootstrap.QueryAudit(configFilePath, filter)
+			if err != nil {
+				log.Fatal("query audit journal error. ", err)
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			for _, r := range records {
+				_ = encoder.Encode(r)
+			}
+		},
+	}
+)
+
+// init 解析命令参数
+func init() {
+	flags := auditCmd.PersistentFlags()
+	flags.StringVarP(&configFilePath, "config", "c", "polaris-cleanup.yaml", "config file path")
+	flags.StringVar(&auditSince, "since", "", "records after the time, RFC3339 time or duration before now like 24h")
+	flags.StringVar(&auditUntil, "until", "", "records before the time, RFC3339 time or duration before now like 1h")
+	flags.StringVar(&auditFilter.Job, "job", "", "records of the job")
+	flags.StringVar(&auditFilter.Namespace, "namespace", "", "records of the namespace")
+	flags.StringVar(&auditFilter.Service, "service", "", "records of the service")
+	flags.StringVar(&auditFilter.ResourceID, "instance", "", "records of the instance or resource id")
+	flags.StringVar(&auditFilter.ID, "id", "", "record of the audit id")
+	flags.IntVar(&auditFilter.Limit, "limit", 0, "max number of newest records, 0 means no limit")
+}
+
+func buildAuditFilter() (audit.Filter, error) {
+	filter := auditFilter
+	var err error
+	if filter.Since, err = parseTime(auditSince); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseTime(auditUntil); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseTime 解析 RFC3339 格式的时间，或者相对当前时间的 duration
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s, should be RFC3339 time or duration", value)
+	}
+	return t, nil
+}
@@ -21,4 +21,8 @@ func init() {
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(revisionCmd)
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(doctorCmd)
 }
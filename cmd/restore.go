@@ -0,0 +1,80 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/polarismesh/polaris-cleanup/bootstrap"
+	"github.com/polarismesh/polaris-cleanup/restore"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// restoreJob 与 audit 命令的 --job 默认值不同，不与 auditFilter 共用
+	restoreJob string
+
+	restoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "restore the deleted instances from the audit journal",
+		Long:  "this command re-registers the deleted instances recorded in the audit journal through the polaris api",
+		Run: func(_ *cobra.Command, _ []string) {
+			filter, err := buildAuditFilter()
+			filter.Job = restoreJob
+			if err == nil && filter.ID == "" && filter.Since.IsZero() && filter.Service == "" &&
+				filter.ResourceID == "" {
+				err = errors.New("one of --id, --since, --service or --instance is required")
+			}
+			if err != nil {
+				log.Fatal("invalid restore filter. ", err)
+			}
+
+			results, err := bootstrap.RestoreInstances(configFilePath, filter)
+			if err != nil {
+				log.Fatal("restore instances error. ", err)
+			}
+			failed := 0
+			for _, r := range results {
+				if !r.Success {
+					failed++
+				}
+				fmt.Println(r.String())
+			}
+			fmt.Printf("restore %d instances, %d failed\n", len(results), failed)
+			if failed > 0 {
+				log.Fatalf("%d instances fail to restore", failed)
+			}
+		},
+	}
+)
+
+// init 解析命令参数
+func init() {
+	flags := restoreCmd.PersistentFlags()
+	flags.StringVarP(&configFilePath, "config", "c", "polaris-cleanup.yaml", "config file path")
+	flags.StringVar(&auditFilter.ID, "id", "", "restore the instance of the audit id")
+	flags.StringVar(&auditSince, "since", "", "restore instances deleted after the time, RFC3339 time or duration like 24h")
+	flags.StringVar(&auditUntil, "until", "", "restore instances deleted before the time, RFC3339 time or duration like 1h")
+	flags.StringVar(&restoreJob, "job", restore.DefaultJob,
+		"restore instances deleted by the job, instances deleted by other jobs are restored only when set explicitly")
+	flags.StringVar(&auditFilter.Namespace, "namespace", "", "restore instances of the namespace")
+	flags.StringVar(&auditFilter.Service, "service", "", "restore instances of the service")
+	flags.StringVar(&auditFilter.ResourceID, "instance", "", "restore the instance of the id")
+}
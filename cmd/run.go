@@ -0,0 +1,50 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/polarismesh/polaris-cleanup/bootstrap"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runJobs []string
+	force   bool
+
+	runCmd = &cobra.Command{
+		Use:   "run",
+		Short: "run cleanup jobs once",
+		Long:  "this command runs the selected cleanup jobs once in order and exits",
+		Run: func(_ *cobra.Command, _ []string) {
+			err := bootstrap.RunOnce(configFilePath, runJobs, dryRun, force)
+			if err != nil {
+				log.Fatal("run cleanup jobs error. ", err)
+			}
+		},
+	}
+)
+
+// init 解析命令参数
+func init() {
+	runCmd.PersistentFlags().StringVarP(&configFilePath, "config", "c", "polaris-cleanup.yaml", "config file path")
+	runCmd.PersistentFlags().StringArrayVar(&runJobs, "job", nil, "job to run, can be repeated, default is openJob in config")
+	runCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "only report the resources to clean, never delete them")
+	runCmd.PersistentFlags().BoolVar(&force, "force", false, "skip the mass deletion guard and delete all the candidates")
+}
@@ -26,6 +26,7 @@ import (
 
 var (
 	configFilePath = ""
+	dryRun         = false
 
 	startCmd = &cobra.Command{
 		Use:   "start",
@@ -33,7 +34,7 @@ var (
 		Long:  "this command start polaris cleanup",
 		Run: func(_ *cobra.Command, _ []string) {
 			// 初始化任务
-			err := bootstrap.Run(configFilePath)
+			err := bootstrap.Run(configFilePath, dryRun)
 			if err != nil {
 				log.Fatal("add micro service metrics job error. ", err)
 			}
@@ -44,4 +45,5 @@ var (
 // init 解析命令参数
 func init() {
 	startCmd.PersistentFlags().StringVarP(&configFilePath, "config", "c", "polaris-cleanup.yaml", "config file path")
+	startCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "only report the resources to clean, never delete them")
 }
@@ -20,7 +20,7 @@ package common
 import (
 	"errors"
 	"fmt"
-	"math/rand"
+	"net"
 	"os"
 
 	"gopkg.in/yaml.v2"
@@ -28,33 +28,332 @@ import (
 
 // AppConfig agent configuration on startup
 type AppConfig struct {
-	InstanceId string   `yaml:"instanceId"`
-	Store      Store    `yaml:"store"`
-	Server     Server   `yaml:"server"`
-	Cleanup    Cleanup  `yaml:"cleanUp"`
-	OpenJob    []string `yaml:"openJob"`
+	InstanceId string     `yaml:"instanceId"`
+	Store      Store      `yaml:"store"`
+	Server     Server     `yaml:"server"`
+	Cleanup    Cleanup    `yaml:"cleanUp"`
+	OpenJob    JobConfigs `yaml:"openJob"`
+	Kubernetes Kubernetes `yaml:"kubernetes"`
+	// LeaderElection 多副本部署时的选主配置
+	LeaderElection LeaderElection `yaml:"leaderElection"`
+	// Audit 删除审计日志配置
+	Audit Audit `yaml:"audit"`
+	// Metrics 监控指标配置
+	Metrics Metrics `yaml:"metrics"`
+	// Admin 管理接口配置
+	Admin Admin `yaml:"admin"`
+	// Reload 配置文件热加载
+	Reload Reload `yaml:"reload"`
+	// Selector 所有任务的清理范围
+	Selector Selector `yaml:"selector"`
+	// Guard 批量删除保护配置
+	Guard Guard `yaml:"guard"`
+	// MinInstances 清理不健康实例时每个服务至少保留的实例数
+	MinInstances MinInstances `yaml:"minInstances"`
+	// DryRun 只打印待清理的资源，不做实际删除
+	DryRun bool `yaml:"dryRun"`
+}
+
+// JobConfig 单个任务的配置，未配置的清理参数使用全局的 Cleanup
+type JobConfig struct {
+	Name    string `yaml:"name"`
+	Enable  *bool  `yaml:"enable"`
+	Cron    string `yaml:"cron"`
+	Cleanup `yaml:",inline"`
+	// Rules DeleteSoftDeleteRules 任务要清理的治理规则类型
+	Rules []RuleCleanup `yaml:"rules"`
+	// Selector 任务的清理范围，配置后替换全局的 selector
+	Selector *Selector `yaml:"selector"`
+}
+
+// RuleCleanup 单类治理规则的清理配置，未配置的清理参数使用任务的值
+type RuleCleanup struct {
+	Kind    string `yaml:"kind"`
+	Cleanup `yaml:",inline"`
+}
+
+// Merge 使用 base 补全未配置的清理参数
+func (c Cleanup) Merge(base Cleanup) Cleanup {
+	if c.LimitedTime == 0 {
+		c.LimitedTime = base.LimitedTime
+	}
+	if c.LimitedNum == 0 {
+		c.LimitedNum = base.LimitedNum
+	}
+	if c.BatchDeleteNum == 0 {
+		c.BatchDeleteNum = base.BatchDeleteNum
+	}
+	if c.PageSize == 0 {
+		c.PageSize = base.PageSize
+	}
+	if c.MaxRunTime == 0 {
+		c.MaxRunTime = base.MaxRunTime
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = base.Concurrency
+	}
+	if c.DeleteRate == 0 {
+		c.DeleteRate = base.DeleteRate
+	}
+	if c.SlowBatchTime == 0 {
+		c.SlowBatchTime = base.SlowBatchTime
+	}
+	return c
+}
+
+// UnmarshalYAML 兼容只填写任务名的配置方式
+func (j *JobConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*j = JobConfig{Name: name}
+		return nil
+	}
+	type plain JobConfig
+	return unmarshal((*plain)(j))
+}
+
+// IsEnable 任务是否开启，未配置时默认开启
+func (j JobConfig) IsEnable() bool {
+	return j.Enable == nil || *j.Enable
 }
 
 type Server struct {
 	Endpoints     []string `yaml:"endpoints"`
 	AuthToken     string   `yaml:"authToken"`
 	RequestPrefix string   `yaml:"requestPrefix"`
+	// Timeout 单次请求的超时时间，单位秒，默认 10
+	Timeout int `yaml:"timeout"`
+	// MaxRetries 幂等请求失败后的最大重试次数，默认 2，小于 0 时不重试
+	MaxRetries int `yaml:"maxRetries"`
+	// RetryBackoff 第一次重试前的等待时间，单位毫秒，之后每次翻倍，默认 200
+	RetryBackoff int `yaml:"retryBackoff"`
+	// EjectFailures 接入点连续失败多少次后被暂时摘除，默认 3
+	EjectFailures int `yaml:"ejectFailures"`
+	// EjectTime 接入点被摘除的时长，单位秒，默认 30
+	EjectTime int `yaml:"ejectTime"`
+	// Scheme 访问接入点时是否使用 TLS，http 或者 https，默认 http，对 gRPC 接入点同样生效
+	Scheme string `yaml:"scheme"`
+	// TLS scheme 为 https 时的证书配置
+	TLS ServerTLS `yaml:"tls"`
+	// Protocol 接入点提供的接口，http 或者 grpc，默认 http
+	Protocol string `yaml:"protocol"`
+	// HTTPEndpoints protocol 为 grpc 时，删除服务、恢复实例等 gRPC 接口不支持的操作使用的 HTTP 接入点
+	HTTPEndpoints []string `yaml:"httpEndpoints"`
 }
 
 type Cleanup struct {
-	LimitedTime    int `yaml:"deleteLimitedTime"`
-	LimitedNum     int `yaml:"deleteLimitedNum"`
+	LimitedTime    int `yaml:"deleteLimitedTime" envconfig:"deleteLimitedTime"`
+	LimitedNum     int `yaml:"deleteLimitedNum" envconfig:"deleteLimitedNum"`
 	BatchDeleteNum int `yaml:"batchDeleteNum"`
+	// PageSize 按主键分页查询待清理实例时每页的行数，每页处理完再查询下一页，默认为 500
+	PageSize int `yaml:"pageSize"`
+	// MaxRunTime 单次执行分页查询待清理实例的时间预算，单位秒，超过后不再查询下一页，为 0 表示不限制
+	MaxRunTime int `yaml:"maxRunTime"`
+	// Concurrency 同时执行删除的批次数，默认为 1
+	Concurrency int `yaml:"concurrency"`
+	// DeleteRate 每秒最多删除的资源数，默认为 batchDeleteNum，即每秒一批
+	DeleteRate int `yaml:"deleteRate"`
+	// SlowBatchTime 一批删除耗时超过多少毫秒时认为数据库或者北极星负载过高并降低删除速率，默认为 1000
+	SlowBatchTime int `yaml:"slowBatchTime"`
+}
+
+// LeaderElection 选主配置，开启后只有 leader 会执行任务
+type LeaderElection struct {
+	Enable bool `yaml:"enable"`
+	// Type 选主方式，mysql 或者 kubernetes，默认为 mysql
+	Type string `yaml:"type"`
+	// LockName mysql 方式下为 GET_LOCK 的锁名，kubernetes 方式下为 Lease 的名称
+	LockName string `yaml:"lockName"`
+	// Namespace kubernetes 方式下 Lease 所在的命名空间
+	Namespace string `yaml:"namespace"`
+	// LeaseDuration leader 失效后多久可以被其他副本接管，单位秒
+	LeaseDuration int `yaml:"leaseDuration"`
+}
+
+// Audit 删除审计日志配置，开启后每次删除前都会记录被删除资源的完整信息
+type Audit struct {
+	Enable bool `yaml:"enable"`
+	// Type 审计日志的存储方式，file 或者 mysql，默认为 file
+	Type string `yaml:"type"`
+	// Path file 方式下 JSON lines 文件的路径
+	Path string `yaml:"path"`
+}
+
+// MinInstances 清理不健康实例时服务至少保留的实例数，为 0 表示不检查
+type MinInstances struct {
+	// Total 删除后服务至少剩余的实例数，超出的不健康实例不删除
+	Total int `yaml:"total"`
+	// Healthy 服务的健康实例少于该值时，更可能是服务整体故障，不删除该服务的任何不健康实例
+	Healthy int `yaml:"healthy"`
+	// Overrides 按命名空间或者服务覆盖全局的配置，服务级别的优先
+	Overrides MinInstancesOverrides `yaml:"overrides"`
+}
+
+// MinInstancesOverride 命名空间或者服务级别的最少实例数，Service 为空时对整个命名空间生效
+type MinInstancesOverride struct {
+	Namespace string `yaml:"namespace"`
+	Service   string `yaml:"service"`
+	Total     int    `yaml:"total"`
+	Healthy   int    `yaml:"healthy"`
+}
+
+// For 获取服务生效的最少实例数
+func (m MinInstances) For(namespace, service string) (total, healthy int) {
+	total, healthy = m.Total, m.Healthy
+	for _, o := range m.Overrides {
+		if o.Namespace != namespace {
+			continue
+		}
+		if o.Service == service {
+			return o.Total, o.Healthy
+		}
+		if o.Service == "" {
+			total, healthy = o.Total, o.Healthy
+		}
+	}
+	return total, healthy
+}
+
+// Enabled 是否配置了任何最少实例数
+func (m MinInstances) Enabled() bool {
+	if m.Total > 0 || m.Healthy > 0 {
+		return true
+	}
+	for _, o := range m.Overrides {
+		if o.Total > 0 || o.Healthy > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Guard 批量删除保护，待删除的实例占比过高时更可能是网络分区等故障，此时不做删除；阈值为 0 表示不检查
+type Guard struct {
+	// MaxDeleteNum 单次任务最多删除的实例数
+	MaxDeleteNum int `yaml:"maxDeleteNum"`
+	// MaxDeletePercent 单次任务最多删除的实例占全部实例的百分比
+	MaxDeletePercent float64 `yaml:"maxDeletePercent"`
+	// ServiceMaxDeleteNum 单个服务最多删除的实例数
+	ServiceMaxDeleteNum int `yaml:"serviceMaxDeleteNum"`
+	// ServiceMaxDeletePercent 单个服务最多删除的实例占该服务实例的百分比
+	ServiceMaxDeletePercent float64 `yaml:"serviceMaxDeletePercent"`
+	// ServiceMinInstances 实例数少于该值的服务不检查百分比，避免只有一两个实例的服务永远无法清理
+	ServiceMinInstances int `yaml:"serviceMinInstances"`
+	// Action 触发保护后的处理方式，abort 时任务失败，hold 时任务跳过本次删除，默认为 abort
+	Action string `yaml:"action"`
+	// Webhook 触发保护时以 POST 方式通知的地址
+	Webhook string `yaml:"webhook"`
+	// Force 跳过保护强制删除，只能通过命令行参数开启
+	Force bool `yaml:"-" ignored:"true"`
+}
+
+// Reload 配置文件热加载，除收到 SIGHUP 信号外，开启 watch 后配置文件变化时也会重新加载；本身的修改需要重启后生效
+type Reload struct {
+	Watch bool `yaml:"watch"`
+	// Interval 检查配置文件是否变化的间隔秒数，默认为 10
+	Interval int `yaml:"interval"`
+}
+
+// Admin 管理接口配置，可以在运行时查看、触发、暂停和恢复任务；与 metrics 的监听地址相同时共用一个 HTTP 服务
+type Admin struct {
+	Enable bool `yaml:"enable"`
+	// Listen 监听地址，默认为 0.0.0.0:9090
+	Listen string `yaml:"listen"`
+	// Token 不为空时，POST 请求需要携带 Authorization: Bearer <token>；监听非回环地址时必须配置
+	Token string `yaml:"token"`
+}
+
+// Validate 校验管理接口配置，未配置 token 时只允许监听回环地址，避免任何能访问到 pod 的人都可以触发删除
+func (a Admin) Validate() error {
+	if !a.Enable || a.Token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(a.Listen)
+	if err != nil && a.Listen != "" {
+		return fmt.Errorf("invalid admin.listen %s, %v", a.Listen, err)
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	return fmt.Errorf("admin.token is required when admin.listen %q is not a loopback address", a.Listen)
 }
 
+// Metrics 监控指标配置，开启后通过 HTTP 的 /metrics 以 Prometheus 格式输出
+type Metrics struct {
+	Enable bool `yaml:"enable"`
+	// Listen 监听地址，默认为 0.0.0.0:9090
+	Listen string `yaml:"listen"`
+}
+
+// Kubernetes Kubernetes 集群的访问配置
+type Kubernetes struct {
+	// KubeConfig kubeconfig 文件路径，为空时使用 in-cluster 配置
+	KubeConfig string `yaml:"kubeConfig"`
+	// CheckNodes 为 true 时，节点 IP 上的实例也认为有效，适用于 hostNetwork 的 pod
+	CheckNodes bool `yaml:"checkNodes"`
+	// Namespaces 北极星命名空间与 Kubernetes 命名空间的映射
+	Namespaces NamespaceMappings `yaml:"namespaces"`
+}
+
+// NamespaceMapping 北极星命名空间与 Kubernetes 命名空间的映射
+type NamespaceMapping struct {
+	Polaris    string `yaml:"polaris"`
+	Kubernetes string `yaml:"kubernetes"`
+}
+
+const (
+	// StoreTypeMysql 北极星集群版使用的 MySQL 存储
+	StoreTypeMysql = "mysql"
+	// StoreTypeBoltDB 北极星单机版内置的 BoltDB 文件存储
+	StoreTypeBoltDB = "boltdb"
+)
+
 type Store struct {
+	// Type 存储类型，mysql 或者 boltdb，默认为 mysql
+	Type   string `yaml:"type"`
 	DbHost string `yaml:"dbHost"`
 	DbPort int    `yaml:"dbPort"`
 	DbName string `yaml:"dbName"`
 	DbUser string `yaml:"dbUser"`
 	DbPwd  string `yaml:"dbPwd"`
+	// Replicas 只读副本的连接串，如 user:pwd@tcp(127.0.0.1:3306)/polaris_server，
+	// 配置后在副本上查询待清理的资源，删除前在主库上复查
+	Replicas []string `yaml:"replicas"`
+	// Path boltdb 时北极星单机版的数据文件，北极星运行时会锁住该文件，需要停止北极星或者使用数据文件的快照副本
+	Path string `yaml:"path"`
+	// Compact boltdb 时关闭前压缩有删除的数据文件
+	Compact bool `yaml:"compact"`
+}
+
+// IsBoltDB 是否使用北极星单机版的数据文件
+func (s Store) IsBoltDB() bool {
+	return s.Type == StoreTypeBoltDB
+}
+
+// DataSource MySQL 的连接串
+func (s Store) DataSource() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", s.DbUser, s.DbPwd, s.DbHost, s.DbPort, s.DbName)
+}
+
+// Validate 校验存储配置
+func (s Store) Validate() error {
+	switch s.Type {
+	case "", StoreTypeMysql:
+		return nil
+	case StoreTypeBoltDB:
+		if s.Path == "" {
+			return fmt.Errorf("store.path is required when store.type is %s", StoreTypeBoltDB)
+		}
+		if len(s.Replicas) > 0 {
+			return fmt.Errorf("store.replicas is not supported when store.type is %s", StoreTypeBoltDB)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid store.type %s, should be %s or %s", s.Type, StoreTypeMysql, StoreTypeBoltDB)
+	}
 }
 
+// LoadConfig 加载配置，依次使用 YAML 文件、POLARIS_CLEANUP_* 环境变量以及 *_FILE 指向的文件中的值
 func LoadConfig(filePath string) (*AppConfig, error) {
 	if filePath == "" {
 		err := errors.New("invalid config file path")
@@ -77,10 +376,81 @@ func LoadConfig(filePath string) (*AppConfig, error) {
 		fmt.Printf("[ERROR] %v\n", err)
 		return nil, err
 	}
+	if err = applyEnv(config); err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
+		return nil, err
+	}
+	if err = config.validateSelectors(); err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
+		return nil, err
+	}
+	if err = config.Store.Validate(); err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
+		return nil, err
+	}
+	if err = config.Server.Validate(); err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
+		return nil, err
+	}
+	if err = config.Admin.Validate(); err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
+		return nil, err
+	}
 
 	return config, nil
 }
 
-func (s Server) ChooseOneEndpoint() string {
-	return s.Endpoints[rand.Intn(len(s.Endpoints))]
+// validateSelectors 校验全局和各个任务的 selector
+func (c AppConfig) validateSelectors() error {
+	if _, err := c.Selector.Compile(); err != nil {
+		return fmt.Errorf("invalid selector, %v", err)
+	}
+	for _, job := range c.OpenJob {
+		if job.Selector == nil {
+			continue
+		}
+		if _, err := job.Selector.Compile(); err != nil {
+			return fmt.Errorf("invalid selector of job %s, %v", job.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetJobConfig 获取任务的配置，任务不在 openJob 中时返回只有任务名的配置
+func (c AppConfig) GetJobConfig(name string) JobConfig {
+	for _, j := range c.OpenJob {
+		if j.Name == name {
+			return j
+		}
+	}
+	return JobConfig{Name: name}
+}
+
+// EnabledJobs 返回开启的任务名
+func (c AppConfig) EnabledJobs() []string {
+	names := make([]string, 0, len(c.OpenJob))
+	for _, j := range c.OpenJob {
+		if j.IsEnable() {
+			names = append(names, j.Name)
+		}
+	}
+	return names
+}
+
+// ForJob 返回任务使用的配置，Cleanup 为任务单独配置的值与全局值合并后的结果，任务配置了 selector 时替换全局的
+func (c AppConfig) ForJob(name string) AppConfig {
+	job := c.GetJobConfig(name)
+	c.Cleanup = job.Cleanup.Merge(c.Cleanup)
+	if job.Selector != nil {
+		c.Selector = *job.Selector
+	}
+	return c
+}
+
+// JobCronSpec 获取任务的 cron 表达式，未配置时使用任务默认的表达式
+func (c AppConfig) JobCronSpec(name, defaultSpec string) string {
+	if spec := c.GetJobConfig(name).Cron; spec != "" {
+		return spec
+	}
+	return defaultSpec
 }
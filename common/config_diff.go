@@ -0,0 +1,154 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	redacted = "******"
+)
+
+// secretKeys 输出配置时需要隐藏取值的字段
+var secretKeys = map[string]bool{
+	"dbPwd":     true,
+	"replicas":  true,
+	"authToken": true,
+	"token":     true,
+	"webhook":   true,
+}
+
+// DiffConfig 对比两份配置，返回有变化的字段，格式为 "字段: 旧值 -> 新值"，敏感字段的取值会被隐藏
+func DiffConfig(oldCfg, newCfg AppConfig) ([]string, error) {
+	oldValues, oldSecrets, err := flattenConfig(oldCfg)
+	if err != nil {
+		return nil, err
+	}
+	newValues, newSecrets, err := flattenConfig(newCfg)
+	if err != nil {
+		return nil, err
+	}
+	display := func(secrets map[string]bool, k, v string) string {
+		if secrets[k] && v != "" {
+			return redacted
+		}
+		return v
+	}
+
+	keys := make([]string, 0, len(oldValues)+len(newValues))
+	for k := range oldValues {
+		keys = append(keys, k)
+	}
+	for k := range newValues {
+		if _, ok := oldValues[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var out []string
+	for _, k := range keys {
+		oldValue, oldOk := oldValues[k]
+		newValue, newOk := newValues[k]
+		switch {
+		case !oldOk:
+			out = append(out, fmt.Sprintf("%s: <none> -> %s", k, display(newSecrets, k, newValue)))
+		case !newOk:
+			out = append(out, fmt.Sprintf("%s: %s -> <none>", k, display(oldSecrets, k, oldValue)))
+		case oldValue != newValue:
+			out = append(out, fmt.Sprintf("%s: %s -> %s", k, display(oldSecrets, k, oldValue),
+				display(newSecrets, k, newValue)))
+		}
+	}
+	return out, nil
+}
+
+// Redacted 按字段排序输出所有非零值的配置，每行一个 "字段: 取值"，敏感字段的取值会被隐藏
+func (c AppConfig) Redacted() (string, error) {
+	values, secrets, err := flattenConfig(c)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := values[k]
+		if secrets[k] {
+			v = redacted
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", k, v))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// flattenConfig 将配置展开为 "a.b[0].c" 形式的非零值字段，带有 name 字段的列表元素以 name 作为下标，
+// 同时返回敏感字段的集合
+func flattenConfig(cfg AppConfig) (map[string]string, map[string]bool, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tree interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, nil, err
+	}
+	out, secrets := map[string]string{}, map[string]bool{}
+	flatten("", "", tree, out, secrets)
+	return out, secrets, nil
+}
+
+func flatten(prefix, key string, value interface{}, out map[string]string, secrets map[string]bool) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			name := fmt.Sprint(k)
+			path := name
+			if prefix != "" {
+				path = prefix + "." + name
+			}
+			flatten(path, name, child, out, secrets)
+		}
+	case []interface{}:
+		for i, child := range v {
+			index := fmt.Sprint(i)
+			if m, ok := child.(map[interface{}]interface{}); ok && m["name"] != nil {
+				index = fmt.Sprint(m["name"])
+			}
+			flatten(fmt.Sprintf("%s[%s]", prefix, index), key, child, out, secrets)
+		}
+	case nil:
+	default:
+		// 零值与未配置等价，不参与对比
+		if s := fmt.Sprint(v); s != "" && s != "0" && s != "false" {
+			out[prefix] = s
+		}
+		if secretKeys[key] {
+			secrets[prefix] = true
+		}
+	}
+}
@@ -0,0 +1,54 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactedHidesSecrets(t *testing.T) {
+	cfg := AppConfig{
+		Store:  Store{DbPwd: "db-secret", Replicas: []string{"user:replica-secret@tcp(10.0.0.2:3306)/polaris"}},
+		Server: Server{AuthToken: "auth-secret"},
+		Admin:  Admin{Token: "admin-secret"},
+		Guard:  Guard{Webhook: "https://hooks.example.com/webhook-secret"},
+	}
+	out, err := cfg.Redacted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("Redacted() leaks a secret:\n%s", out)
+	}
+	for _, key := range []string{"store.dbPwd", "server.authToken", "admin.token", "guard.webhook"} {
+		if !strings.Contains(out, key+": "+redacted) {
+			t.Errorf("Redacted() does not hide %s:\n%s", key, out)
+		}
+	}
+
+	newCfg := cfg
+	newCfg.Guard.Webhook = "https://hooks.example.com/another-secret"
+	diff, err := DiffConfig(cfg, newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "guard.webhook: " + redacted + " -> " + redacted; len(diff) != 1 || diff[0] != want {
+		t.Errorf("DiffConfig() = %v, want [%s]", diff, want)
+	}
+}
@@ -0,0 +1,43 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import "testing"
+
+func TestAdminValidate(t *testing.T) {
+	tests := []struct {
+		admin   Admin
+		wantErr bool
+	}{
+		{admin: Admin{Enable: false, Listen: "0.0.0.0:9090"}},
+		{admin: Admin{Enable: true, Listen: "127.0.0.1:9090"}},
+		{admin: Admin{Enable: true, Listen: "[::1]:9090"}},
+		{admin: Admin{Enable: true, Listen: "localhost:9090"}},
+		{admin: Admin{Enable: true, Listen: "0.0.0.0:9090", Token: "secret"}},
+		{admin: Admin{Enable: true, Listen: "0.0.0.0:9090"}, wantErr: true},
+		{admin: Admin{Enable: true, Listen: ":9090"}, wantErr: true},
+		{admin: Admin{Enable: true, Listen: "10.0.0.1:9090"}, wantErr: true},
+		// 未配置时使用默认的 0.0.0.0:9090
+		{admin: Admin{Enable: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.admin.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, want error %v", tt.admin, err, tt.wantErr)
+		}
+	}
+}
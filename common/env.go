@@ -0,0 +1,90 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvPrefix 覆盖配置的环境变量前缀，变量名为前缀加上大写的 YAML 字段路径，如 POLARIS_CLEANUP_STORE_DBPWD
+	EnvPrefix = "POLARIS_CLEANUP"
+	// fileSuffix 环境变量名加上该后缀时，取值为文件路径，从文件中读取配置的值，用于挂载的 Kubernetes Secret
+	fileSuffix = "_FILE"
+)
+
+// applyEnv 使用环境变量覆盖配置，XXX_FILE 指向的文件内容优先于 XXX 的值
+func applyEnv(cfg *AppConfig) error {
+	if err := resolveFileEnv(cfg); err != nil {
+		return err
+	}
+	return envconfig.Process(EnvPrefix, cfg)
+}
+
+// resolveFileEnv 读取所有配置了 XXX_FILE 的文件，将内容设置到环境变量 XXX 中
+func resolveFileEnv(cfg *AppConfig) error {
+	var keys bytes.Buffer
+	if err := envconfig.Usagef(EnvPrefix, cfg, &keys, "{{range .}}{{usage_key .}}\n{{end}}"); err != nil {
+		return err
+	}
+	for _, key := range strings.Fields(keys.String()) {
+		path, ok := os.LookupEnv(key + fileSuffix)
+		if !ok {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read %s%s fail, %v", key, fileSuffix, err)
+		}
+		if err := os.Setenv(key, strings.TrimRight(string(data), "\r\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JobConfigs 任务配置列表，通过环境变量覆盖时取值为 YAML，如 [DeleteEmptyService, {name: DeleteUnHealthyInstance}]
+type JobConfigs []JobConfig
+
+// Decode 实现 envconfig.Decoder
+func (j *JobConfigs) Decode(value string) error {
+	return yaml.Unmarshal([]byte(value), j)
+}
+
+// NamespaceMappings 命名空间映射列表，通过环境变量覆盖时取值为 YAML
+type NamespaceMappings []NamespaceMapping
+
+// Decode 实现 envconfig.Decoder
+func (n *NamespaceMappings) Decode(value string) error {
+	return yaml.Unmarshal([]byte(value), n)
+}
+
+// MinInstancesOverrides 最少实例数的覆盖配置列表，通过环境变量覆盖时取值为 YAML
+type MinInstancesOverrides []MinInstancesOverride
+
+// Decode 实现 envconfig.Decoder
+func (m *MinInstancesOverrides) Decode(value string) error {
+	return yaml.Unmarshal([]byte(value), m)
+}
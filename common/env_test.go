@@ -0,0 +1,82 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// yamlEnvKeys 按照 YAML 的字段路径生成期望的环境变量名
+func yamlEnvKeys(typ reflect.Type, prefix string, keys map[string]bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+		if field.Type.Kind() == reflect.Struct && field.Type.PkgPath() == typ.PkgPath() {
+			yamlEnvKeys(field.Type, key, keys)
+			continue
+		}
+		keys[key] = true
+	}
+}
+
+func TestEnvKeysMatchYAML(t *testing.T) {
+	want := map[string]bool{}
+	yamlEnvKeys(reflect.TypeOf(AppConfig{}), EnvPrefix, want)
+
+	var usage bytes.Buffer
+	if err := envconfig.Usagef(EnvPrefix, &AppConfig{}, &usage, "{{range .}}{{usage_key .}}\n{{end}}"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range strings.Fields(usage.String()) {
+		if !want[key] {
+			t.Errorf("env %s does not match any YAML key", key)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("POLARIS_CLEANUP_CLEANUP_DELETELIMITEDTIME", "7200")
+	t.Setenv("POLARIS_CLEANUP_CLEANUP_DELETELIMITEDNUM", "50")
+	t.Setenv("POLARIS_CLEANUP_STORE_DBPWD", "secret")
+
+	cfg := AppConfig{}
+	if err := applyEnv(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Cleanup.LimitedTime != 7200 || cfg.Cleanup.LimitedNum != 50 {
+		t.Errorf("cleanUp = %+v, want deleteLimitedTime 7200 and deleteLimitedNum 50", cfg.Cleanup)
+	}
+	if cfg.Store.DbPwd != "secret" {
+		t.Errorf("store.dbPwd = %q, want secret", cfg.Store.DbPwd)
+	}
+}
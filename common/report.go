@@ -0,0 +1,265 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/metrics"
+)
+
+const (
+	// KindInstance 服务实例
+	KindInstance = "instance"
+	// KindService 服务
+	KindService = "service"
+)
+
+// Candidate 待清理的资源
+type Candidate struct {
+	// Kind 资源类型，如 instance、service、routing
+	Kind      string
+	ID        string
+	Namespace string
+	Service   string
+	Host      string
+	Port      int
+	Reason    string
+}
+
+// String 用于日志输出
+func (c Candidate) String() string {
+	if c.Host == "" {
+		return fmt.Sprintf("id=%s, service=%s, namespace=%s, reason=%s", c.ID, c.Service, c.Namespace, c.Reason)
+	}
+	return fmt.Sprintf("id=%s, service=%s, namespace=%s, address=%s:%d, reason=%s",
+		c.ID, c.Service, c.Namespace, c.Host, c.Port, c.Reason)
+}
+
+// JobResult 任务最近一次执行的结果
+type JobResult struct {
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	Summary string    `json:"summary,omitempty"`
+}
+
+var (
+	resultLock  sync.RWMutex
+	lastResults = map[string]JobResult{}
+)
+
+// LastResult 获取任务最近一次执行的结果
+func LastResult(job string) (JobResult, bool) {
+	resultLock.RLock()
+	defer resultLock.RUnlock()
+	result, ok := lastResults[job]
+	return result, ok
+}
+
+func recordResult(job string, report *RunReport, err error) {
+	result := JobResult{Time: time.Now(), Success: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if report != nil {
+		result.Summary = report.Summary()
+	}
+	resultLock.Lock()
+	defer resultLock.Unlock()
+	lastResults[job] = result
+}
+
+// RunReport 一次清理任务的执行结果
+type RunReport struct {
+	Job        string
+	DryRun     bool
+	Candidates []Candidate
+	Deleted    int
+	Failed     int
+	// KindDeleted 按资源类型统计的删除数量
+	KindDeleted map[string]int
+	// KindFailed 按资源类型统计的删除失败数量
+	KindFailed map[string]int
+	// Held 触发批量删除保护而跳过删除的原因
+	Held string
+	// Skipped 满足清理条件但因保护规则不删除的资源
+	Skipped []Candidate
+
+	// lock 并发删除时保护删除结果的统计
+	lock sync.Mutex
+}
+
+// NewRunReport 创建任务执行结果
+func NewRunReport(job string, dryRun bool) *RunReport {
+	return &RunReport{Job: job, DryRun: dryRun}
+}
+
+// AddCandidate 记录待清理的资源，dry-run 模式下同时打印出来
+func (r *RunReport) AddCandidate(c Candidate) {
+	r.Candidates = append(r.Candidates, c)
+	if r.DryRun {
+		glog.Infof("[DryRun][%s] would delete %s", r.Job, c)
+	}
+}
+
+// AddSkipped 记录因保护规则不删除的资源，Reason 为跳过的原因
+func (r *RunReport) AddSkipped(c Candidate) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Skipped = append(r.Skipped, c)
+	glog.Infof("[%s] skip %s", r.Job, c)
+}
+
+// SkipChanged 删除前复查后，将 ids 中不在 kept 里的资源记录为跳过，返回仍需删除的 ID
+func (r *RunReport) SkipChanged(kind string, ids, kept []string) []string {
+	if len(kept) == len(ids) {
+		return ids
+	}
+	keep := make(map[string]bool, len(kept))
+	for _, id := range kept {
+		keep[id] = true
+	}
+	out := make([]string, 0, len(kept))
+	var changed []string
+	for _, id := range ids {
+		if keep[id] {
+			out = append(out, id)
+		} else {
+			changed = append(changed, id)
+		}
+	}
+	for _, c := range r.CandidatesOf(kind, changed) {
+		c.Reason = "no longer matches the cleanup condition on the primary database"
+		r.AddSkipped(c)
+	}
+	return out
+}
+
+// CandidatesOf 按资源类型和 ID 获取待清理的资源
+func (r *RunReport) CandidatesOf(kind string, ids []string) []Candidate {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	out := make([]Candidate, 0, len(ids))
+	for _, c := range r.Candidates {
+		if c.Kind == kind && wanted[c.ID] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// AddDeleted 记录某类资源的删除数量
+func (r *RunReport) AddDeleted(kind string, num int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.KindDeleted == nil {
+		r.KindDeleted = map[string]int{}
+	}
+	r.KindDeleted[kind] += num
+	r.Deleted += num
+}
+
+// AddFailed 记录某类资源删除失败的数量
+func (r *RunReport) AddFailed(kind string, num int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.KindFailed == nil {
+		r.KindFailed = map[string]int{}
+	}
+	r.KindFailed[kind] += num
+	r.Failed += num
+}
+
+// FinishRun 输出任务的执行结果，并记录监控指标和最近一次执行的结果
+func FinishRun(job string, report *RunReport, err error) {
+	metrics.ObserveJobResult(job, err)
+	recordResult(job, report, err)
+	if err != nil {
+		glog.Errorf("job=[%s] fail, err: %s", job, err.Error())
+	}
+	if report == nil {
+		return
+	}
+
+	kindCandidates := map[string]int{}
+	for _, c := range report.Candidates {
+		kindCandidates[c.Kind]++
+	}
+	for kind, num := range kindCandidates {
+		metrics.AddCandidates(job, kind, num)
+	}
+	for kind, num := range report.KindDeleted {
+		metrics.AddDeletions(job, kind, num, true)
+	}
+	for kind, num := range report.KindFailed {
+		metrics.AddDeletions(job, kind, num, false)
+	}
+	glog.Info(report.Summary())
+}
+
+// Summary 任务执行结果的概要
+func (r *RunReport) Summary() string {
+	var summary string
+	if r.DryRun {
+		summary = fmt.Sprintf("job=[%s] dry-run, candidates: %d, nothing deleted", r.Job, len(r.Candidates))
+	} else {
+		summary = fmt.Sprintf("job=[%s] candidates: %d, deleted: %d, failed: %d", r.Job, len(r.Candidates),
+			r.Deleted, r.Failed)
+	}
+	if len(r.Skipped) > 0 {
+		summary += fmt.Sprintf(", skipped: %d", len(r.Skipped))
+	}
+	if r.Held != "" {
+		summary += fmt.Sprintf(", held by guard: %s", r.Held)
+	}
+
+	// 按待清理资源的类型分组，只有一种资源类型时无需再按类型输出
+	kindCandidates := map[string]int{}
+	for _, c := range r.Candidates {
+		kindCandidates[c.Kind]++
+	}
+	for kind := range r.KindFailed {
+		if _, ok := kindCandidates[kind]; !ok {
+			kindCandidates[kind] = 0
+		}
+	}
+	if len(kindCandidates) <= 1 {
+		return summary
+	}
+	kinds := make([]string, 0, len(kindCandidates))
+	for kind := range kindCandidates {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if r.DryRun {
+			summary += fmt.Sprintf(", %s(candidates: %d)", kind, kindCandidates[kind])
+			continue
+		}
+		summary += fmt.Sprintf(", %s(candidates: %d, deleted: %d, failed: %d)", kind, kindCandidates[kind],
+			r.KindDeleted[kind], r.KindFailed[kind])
+	}
+	return summary
+}
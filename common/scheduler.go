@@ -18,46 +18,327 @@
 package common
 
 import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/metrics"
 	"github.com/robfig/cron/v3"
 )
 
 // Scheduler
 type Scheduler struct {
 	cron *cron.Cron
+	// isLeader 多副本部署时判断当前副本是否可以执行任务，为空时总是执行
+	isLeader func() bool
+
+	lock sync.RWMutex
+	jobs map[string]*jobEntry
 }
 
 // CronJob 定时任务的通用接口
 type CronJob interface {
+	Name() string
 	CronSpec() string
 	Execute() func()
 }
 
+// JobStatus 任务的调度状态
+type JobStatus struct {
+	Name     string     `json:"name"`
+	CronSpec string     `json:"cronSpec"`
+	Paused   bool       `json:"paused"`
+	Running  bool       `json:"running"`
+	Next     *time.Time `json:"next,omitempty"`
+	Prev     *time.Time `json:"prev,omitempty"`
+	// LastResult 最近一次执行的结果，还没有执行过时为空
+	LastResult *JobResult `json:"lastResult,omitempty"`
+}
+
+// jobEntry 调度器中的一个任务
+type jobEntry struct {
+	name    string
+	spec    string
+	id      cron.EntryID
+	cmd     func()
+	paused  bool
+	running bool
+	// idle 本次执行结束后需要调用的回调，用于释放被替换或者移除的任务持有的资源
+	idle []func()
+}
+
+var (
+	// ErrJobNotFound 任务不存在
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobRunning 任务正在执行
+	ErrJobRunning = errors.New("job is running")
+	// ErrNotLeader 当前副本不是 leader
+	ErrNotLeader = errors.New("not leader")
+
+	cronParser = cron.NewParser(
+		cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+	)
+)
+
 // NewDefaultScheduler
 func NewDefaultScheduler() *Scheduler {
-	c := cron.New(cron.WithChain(cron.Recover(cron.DefaultLogger)), cron.WithParser(cron.NewParser(
-		cron.SecondOptional|cron.Minute|cron.Hour|cron.Dom|cron.Month|cron.Dow|cron.Descriptor,
-	)))
-	return &Scheduler{cron: c}
+	c := cron.New(cron.WithChain(cron.Recover(cron.DefaultLogger)), cron.WithParser(cronParser))
+	return &Scheduler{cron: c, jobs: map[string]*jobEntry{}}
 }
 
-// AddJob
+// ValidateCronSpec 校验 cron 表达式
+func ValidateCronSpec(spec string) error {
+	_, err := cronParser.Parse(spec)
+	return err
+}
+
+// SetLeaderChecker 设置选主判断，只有 leader 才会执行任务
+func (sc *Scheduler) SetLeaderChecker(isLeader func() bool) {
+	sc.isLeader = isLeader
+}
+
+// AddJob 添加任务，同一个任务的上一次执行还没有结束时跳过本次调度，暂停的任务不会被调度；
+// 同名任务已经存在时替换它的调度，并保留暂停和执行中的状态
 func (sc *Scheduler) AddJob(job CronJob) (int, error) {
-	id, err := sc.cron.AddFunc(job.CronSpec(), job.Execute())
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	entry, exist := sc.jobs[job.Name()]
+	if !exist {
+		entry = &jobEntry{name: job.Name()}
+	}
+	id, err := sc.cron.AddFunc(job.CronSpec(), sc.leaderOnly(func() {
+		sc.runEntry(entry)
+	}))
 	if err != nil {
 		return 0, err
 	}
+	if exist {
+		sc.cron.Remove(entry.id)
+	}
+	entry.id = id
+	entry.spec = job.CronSpec()
+	entry.cmd = observeDuration(job.Name(), job.Execute())
+	sc.jobs[entry.name] = entry
 	return int(id), nil
 }
 
+// RemoveJob 移除任务的调度，正在执行的不受影响；release 不为空时在任务不再执行后调用
+func (sc *Scheduler) RemoveJob(name string, release func()) error {
+	sc.lock.Lock()
+	entry, ok := sc.jobs[name]
+	if !ok {
+		sc.lock.Unlock()
+		return ErrJobNotFound
+	}
+	sc.cron.Remove(entry.id)
+	delete(sc.jobs, name)
+	now := release != nil && sc.whenIdle(entry, release)
+	sc.lock.Unlock()
+
+	if now {
+		release()
+	}
+	return nil
+}
+
+// AfterRun 任务没有在执行时立即调用 fn，否则在本次执行结束后调用，
+// 用于替换任务后释放旧任务持有的资源，避免打断旧任务正在进行的执行
+func (sc *Scheduler) AfterRun(name string, fn func()) error {
+	sc.lock.Lock()
+	entry, ok := sc.jobs[name]
+	if !ok {
+		sc.lock.Unlock()
+		return ErrJobNotFound
+	}
+	now := sc.whenIdle(entry, fn)
+	sc.lock.Unlock()
+
+	if now {
+		fn()
+	}
+	return nil
+}
+
+// whenIdle 需要持有锁调用，任务正在执行时登记回调并返回 false，否则返回 true 由调用方立即执行
+func (sc *Scheduler) whenIdle(entry *jobEntry, fn func()) bool {
+	if !entry.running {
+		return true
+	}
+	entry.idle = append(entry.idle, fn)
+	return false
+}
+
 // AddFunc
 func (sc *Scheduler) AddFunc(cron string, cmd func()) (int, error) {
-	id, err := sc.cron.AddFunc(cron, cmd)
+	id, err := sc.cron.AddFunc(cron, sc.leaderOnly(cmd))
 	if err != nil {
 		return 0, err
 	}
 	return int(id), nil
 }
 
+// runEntry 定时调度执行任务，暂停或者仍在执行中的任务跳过本次调度
+func (sc *Scheduler) runEntry(entry *jobEntry) {
+	sc.lock.Lock()
+	if entry.paused {
+		sc.lock.Unlock()
+		return
+	}
+	if entry.running {
+		sc.lock.Unlock()
+		glog.Warningf("[Scheduler] job=[%s] is still running, skip this run", entry.name)
+		return
+	}
+	entry.running = true
+	cmd := entry.cmd
+	sc.lock.Unlock()
+
+	sc.execute(entry, cmd)
+}
+
+// execute 执行已经标记为 running 的任务，结束后清除标记并调用登记的回调
+func (sc *Scheduler) execute(entry *jobEntry, cmd func()) {
+	defer func() {
+		sc.lock.Lock()
+		entry.running = false
+		idle := entry.idle
+		entry.idle = nil
+		sc.lock.Unlock()
+
+		for _, fn := range idle {
+			fn()
+		}
+	}()
+	cmd()
+}
+
+// Jobs 获取所有任务的调度状态，按任务名排序
+func (sc *Scheduler) Jobs() []JobStatus {
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
+
+	out := make([]JobStatus, 0, len(sc.jobs))
+	for _, entry := range sc.jobs {
+		out = append(out, sc.status(entry))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Job 获取单个任务的调度状态
+func (sc *Scheduler) Job(name string) (JobStatus, error) {
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
+
+	entry, ok := sc.jobs[name]
+	if !ok {
+		return JobStatus{}, ErrJobNotFound
+	}
+	return sc.status(entry), nil
+}
+
+func (sc *Scheduler) status(entry *jobEntry) JobStatus {
+	status := JobStatus{
+		Name:     entry.name,
+		CronSpec: entry.spec,
+		Paused:   entry.paused,
+		Running:  entry.running,
+	}
+	cronEntry := sc.cron.Entry(entry.id)
+	if !entry.paused && !cronEntry.Next.IsZero() {
+		next := cronEntry.Next
+		status.Next = &next
+	}
+	if !cronEntry.Prev.IsZero() {
+		prev := cronEntry.Prev
+		status.Prev = &prev
+	}
+	if result, ok := LastResult(entry.name); ok {
+		status.LastResult = &result
+	}
+	return status
+}
+
+// RunJob 在后台立即执行一次任务，暂停的任务也可以手动执行
+func (sc *Scheduler) RunJob(name string) error {
+	if sc.isLeader != nil && !sc.isLeader() {
+		return ErrNotLeader
+	}
+	sc.lock.Lock()
+	entry, ok := sc.jobs[name]
+	if !ok {
+		sc.lock.Unlock()
+		return ErrJobNotFound
+	}
+	if entry.running {
+		sc.lock.Unlock()
+		return ErrJobRunning
+	}
+	entry.running = true
+	cmd := entry.cmd
+	sc.lock.Unlock()
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				glog.Errorf("[Scheduler] job=[%s] panic: %v", name, err)
+			}
+		}()
+		sc.execute(entry, cmd)
+	}()
+	return nil
+}
+
+// PauseJob 暂停任务的调度，正在执行的不受影响
+func (sc *Scheduler) PauseJob(name string) error {
+	return sc.setPaused(name, true)
+}
+
+// ResumeJob 恢复任务的调度
+func (sc *Scheduler) ResumeJob(name string) error {
+	return sc.setPaused(name, false)
+}
+
+func (sc *Scheduler) setPaused(name string, paused bool) error {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	entry, ok := sc.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	entry.paused = paused
+	glog.Infof("[Scheduler] job=[%s] paused=%v", name, paused)
+	return nil
+}
+
+// observeDuration 记录任务执行耗时的监控指标
+func observeDuration(name string, cmd func()) func() {
+	return func() {
+		start := time.Now()
+		defer func() {
+			metrics.ObserveJobDuration(name, time.Since(start))
+		}()
+		cmd()
+	}
+}
+
+// leaderOnly 开启选主时，非 leader 副本跳过任务的执行
+func (sc *Scheduler) leaderOnly(cmd func()) func() {
+	return func() {
+		if sc.isLeader != nil && !sc.isLeader() {
+			glog.V(1).Info("[Scheduler] not leader, skip the job")
+			return
+		}
+		cmd()
+	}
+}
+
 // Start
 func (sc *Scheduler) Start() {
 	sc.cron.Start()
@@ -0,0 +1,99 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+type blockingJob struct {
+	name    string
+	started chan struct{}
+	finish  chan struct{}
+}
+
+func (j *blockingJob) Name() string     { return j.name }
+func (j *blockingJob) CronSpec() string { return "@every 1h" }
+func (j *blockingJob) Execute() func() {
+	return func() {
+		close(j.started)
+		<-j.finish
+	}
+}
+
+func newBlockingJob(name string) *blockingJob {
+	return &blockingJob{name: name, started: make(chan struct{}), finish: make(chan struct{})}
+}
+
+func TestSchedulerReleaseAfterRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		running bool
+		remove  bool
+	}{
+		{name: "replace idle job"},
+		{name: "replace running job", running: true},
+		{name: "remove idle job", remove: true},
+		{name: "remove running job", running: true, remove: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewDefaultScheduler()
+			task := newBlockingJob("job")
+			if _, err := sc.AddJob(task); err != nil {
+				t.Fatal(err)
+			}
+			if tt.running {
+				if err := sc.RunJob("job"); err != nil {
+					t.Fatal(err)
+				}
+				<-task.started
+			}
+
+			released := make(chan struct{})
+			release := func() { close(released) }
+			var err error
+			if tt.remove {
+				err = sc.RemoveJob("job", release)
+			} else {
+				if _, err = sc.AddJob(newBlockingJob("job")); err != nil {
+					t.Fatal(err)
+				}
+				err = sc.AfterRun("job", release)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.running {
+				select {
+				case <-released:
+					t.Fatal("released while the job is still running")
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(task.finish)
+			}
+			select {
+			case <-released:
+			case <-time.After(time.Second):
+				t.Fatal("not released after the job finished")
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// regexPrefix 服务名匹配规则以该前缀开头时按正则表达式匹配整个服务名，否则按 glob 匹配
+	regexPrefix = "regex:"
+)
+
+// Selector 任务清理的资源范围，未配置的条件不做过滤，exclude 优先于 include
+type Selector struct {
+	// Namespaces 只清理这些命名空间下的资源
+	Namespaces []string `yaml:"namespaces"`
+	// ExcludeNamespaces 不清理这些命名空间下的资源
+	ExcludeNamespaces []string `yaml:"excludeNamespaces"`
+	// Services 只清理名称匹配的服务及其实例，支持 glob，以 regex: 开头时为匹配整个名称的正则表达式
+	Services []string `yaml:"services"`
+	// ExcludeServices 不清理名称匹配的服务及其实例
+	ExcludeServices []string `yaml:"excludeServices"`
+	// Hosts 只清理 host 属于这些网段的实例，支持 CIDR 或者单个 IP
+	Hosts []string `yaml:"hosts"`
+	// ExcludeHosts 不清理 host 属于这些网段的实例，配置后 host 不是 IP 的实例也不清理
+	ExcludeHosts []string `yaml:"excludeHosts"`
+}
+
+// IsEmpty 是否没有配置任何条件
+func (s Selector) IsEmpty() bool {
+	return len(s.Namespaces) == 0 && len(s.ExcludeNamespaces) == 0 && len(s.Services) == 0 &&
+		len(s.ExcludeServices) == 0 && len(s.Hosts) == 0 && len(s.ExcludeHosts) == 0
+}
+
+// Matcher 编译后的 Selector
+type Matcher struct {
+	namespaces        map[string]bool
+	excludeNamespaces map[string]bool
+	services          []func(string) bool
+	excludeServices   []func(string) bool
+	hosts             []*net.IPNet
+	excludeHosts      []*net.IPNet
+}
+
+// Compile 编译服务名匹配规则和网段
+func (s Selector) Compile() (*Matcher, error) {
+	m := &Matcher{
+		namespaces:        toSet(s.Namespaces),
+		excludeNamespaces: toSet(s.ExcludeNamespaces),
+	}
+	var err error
+	if m.services, err = compilePatterns(s.Services); err != nil {
+		return nil, err
+	}
+	if m.excludeServices, err = compilePatterns(s.ExcludeServices); err != nil {
+		return nil, err
+	}
+	if m.hosts, err = parseNets(s.Hosts); err != nil {
+		return nil, err
+	}
+	if m.excludeHosts, err = parseNets(s.ExcludeHosts); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Match 资源是否在清理范围内，host 为空的资源（如服务）不做网段过滤；
+// 配置了网段时无法判断 host 不是 IP 的实例是否属于这些网段，按不在清理范围内处理
+func (m *Matcher) Match(namespace, service, host string) bool {
+	if m.excludeNamespaces[namespace] {
+		return false
+	}
+	if len(m.namespaces) > 0 && !m.namespaces[namespace] {
+		return false
+	}
+	if matchAny(m.excludeServices, service) {
+		return false
+	}
+	if len(m.services) > 0 && !matchAny(m.services, service) {
+		return false
+	}
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if len(m.excludeHosts) > 0 && (ip == nil || containsIP(m.excludeHosts, ip)) {
+		return false
+	}
+	if len(m.hosts) > 0 && !containsIP(m.hosts, ip) {
+		return false
+	}
+	return true
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func compilePatterns(patterns []string) ([]func(string) bool, error) {
+	out := make([]func(string) bool, 0, len(patterns))
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, regexPrefix) {
+			// 与 glob 一致匹配整个服务名，避免 regex:foo 选中 foo-canary 等服务
+			re, err := regexp.Compile("^(?:" + strings.TrimPrefix(pattern, regexPrefix) + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid service pattern %s, %v", pattern, err)
+			}
+			out = append(out, re.MatchString)
+			continue
+		}
+		// 提前校验 glob 语法，匹配时不会再出错
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid service pattern %s, %v", pattern, err)
+		}
+		glob := pattern
+		out = append(out, func(name string) bool {
+			ok, _ := path.Match(glob, name)
+			return ok
+		})
+	}
+	return out, nil
+}
+
+func matchAny(patterns []func(string) bool, name string) bool {
+	for _, match := range patterns {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNets(values []string) ([]*net.IPNet, error) {
+	out := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid host %s", v)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			v = fmt.Sprintf("%s/%d", v, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host cidr %s, %v", v, err)
+		}
+		out = append(out, ipNet)
+	}
+	return out, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import "testing"
+
+func TestSelectorMatch(t *testing.T) {
+	type resource struct {
+		namespace, service, host string
+		want                     bool
+	}
+	tests := []struct {
+		name      string
+		selector  Selector
+		resources []resource
+	}{
+		{
+			name:     "empty selector",
+			selector: Selector{},
+			resources: []resource{
+				{"Test", "foo", "10.0.0.1", true},
+				{"Test", "foo", "", true},
+				{"Test", "foo", "foo.example.com", true},
+			},
+		},
+		{
+			name:     "namespaces",
+			selector: Selector{Namespaces: []string{"Test", "Development"}, ExcludeNamespaces: []string{"Development"}},
+			resources: []resource{
+				{"Test", "foo", "", true},
+				{"Development", "foo", "", false},
+				{"Production", "foo", "", false},
+			},
+		},
+		{
+			name:     "glob",
+			selector: Selector{Services: []string{"order-*"}, ExcludeServices: []string{"order-canary"}},
+			resources: []resource{
+				{"Test", "order-v1", "", true},
+				{"Test", "order-canary", "", false},
+				{"Test", "xorder-v1", "", false},
+				{"Test", "pay", "", false},
+			},
+		},
+		{
+			name:     "regex matches the whole name",
+			selector: Selector{Services: []string{"regex:pay-(v1|v2)", "regex:^foo$"}},
+			resources: []resource{
+				{"Test", "pay-v1", "", true},
+				{"Test", "pay-v2", "", true},
+				{"Test", "pay-v1-canary", "", false},
+				{"Test", "xpay-v1", "", false},
+				{"Test", "foo", "", true},
+				{"Test", "foo-canary", "", false},
+			},
+		},
+		{
+			name:     "exclude regex",
+			selector: Selector{ExcludeServices: []string{"regex:foo"}},
+			resources: []resource{
+				{"Test", "foo", "", false},
+				{"Test", "foo-canary", "", true},
+			},
+		},
+		{
+			name:     "hosts",
+			selector: Selector{Hosts: []string{"10.0.0.0/24", "192.168.1.1"}, ExcludeHosts: []string{"10.0.0.128/25"}},
+			resources: []resource{
+				{"Test", "foo", "10.0.0.1", true},
+				{"Test", "foo", "10.0.0.200", false},
+				{"Test", "foo", "192.168.1.1", true},
+				{"Test", "foo", "192.168.1.2", false},
+				{"Test", "foo", "foo.example.com", false},
+				{"Test", "foo", "", true},
+			},
+		},
+		{
+			name:     "exclude hosts fails closed",
+			selector: Selector{ExcludeHosts: []string{"10.0.0.0/8", "fd00::1"}},
+			resources: []resource{
+				{"Test", "foo", "10.1.2.3", false},
+				{"Test", "foo", "fd00::1", false},
+				{"Test", "foo", "fd00::2", true},
+				{"Test", "foo", "172.16.0.1", true},
+				{"Test", "foo", "foo.example.com", false},
+				{"Test", "foo", "", true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		m, err := tt.selector.Compile()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, r := range tt.resources {
+			if got := m.Match(r.namespace, r.service, r.host); got != r.want {
+				t.Errorf("%s: Match(%s, %s, %s) = %v, want %v", tt.name, r.namespace, r.service, r.host, got, r.want)
+			}
+		}
+	}
+}
+
+func TestSelectorCompileError(t *testing.T) {
+	tests := []Selector{
+		{Services: []string{"regex:("}},
+		{ExcludeServices: []string{"[a-"}},
+		{Hosts: []string{"foo.example.com"}},
+		{ExcludeHosts: []string{"10.0.0.0/33"}},
+	}
+	for _, s := range tests {
+		if _, err := s.Compile(); err == nil {
+			t.Errorf("Compile(%+v) want error", s)
+		}
+	}
+}
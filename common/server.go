@@ -0,0 +1,123 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
+// ServerTLS 访问北极星接入点的 TLS 配置，同时配置 certFile 和 keyFile 时使用双向认证
+type ServerTLS struct {
+	// CaFile 校验服务端证书的 CA，为空时使用系统的根证书
+	CaFile string `yaml:"caFile"`
+	// CertFile 客户端证书
+	CertFile string `yaml:"certFile"`
+	// KeyFile 客户端证书的私钥
+	KeyFile string `yaml:"keyFile"`
+	// ServerName 校验服务端证书时使用的域名，为空时使用接入点的地址
+	ServerName string `yaml:"serverName"`
+	// InsecureSkipVerify 不校验服务端证书，只用于测试环境
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+}
+
+// IsEmpty 是否没有任何 TLS 配置
+func (t ServerTLS) IsEmpty() bool {
+	return t == ServerTLS{}
+}
+
+// URLScheme 访问接入点的协议
+func (s Server) URLScheme() string {
+	if s.Scheme == "" {
+		return SchemeHTTP
+	}
+	return s.Scheme
+}
+
+// HTTP 返回访问 HTTP 接口使用的配置，protocol 为 grpc 时使用 httpEndpoints
+func (s Server) HTTP() Server {
+	if s.Protocol == ProtocolGRPC {
+		s.Protocol = ProtocolHTTP
+		s.Endpoints = s.HTTPEndpoints
+	}
+	return s
+}
+
+// Validate 校验接口类型、协议和证书配置，证书文件不存在或者无法解析时返回错误
+func (s Server) Validate() error {
+	switch s.Protocol {
+	case "", ProtocolHTTP, ProtocolGRPC:
+	default:
+		return fmt.Errorf("invalid server.protocol %s, should be %s or %s", s.Protocol, ProtocolHTTP, ProtocolGRPC)
+	}
+	switch s.URLScheme() {
+	case SchemeHTTP:
+		if !s.TLS.IsEmpty() {
+			return fmt.Errorf("server.tls is set but server.scheme is %s", SchemeHTTP)
+		}
+		return nil
+	case SchemeHTTPS:
+		_, err := s.TLSConfig()
+		return err
+	default:
+		return fmt.Errorf("invalid server.scheme %s, should be %s or %s", s.Scheme, SchemeHTTP, SchemeHTTPS)
+	}
+}
+
+// TLSConfig 根据配置创建 tls.Config，scheme 为 http 时返回 nil
+func (s Server) TLSConfig() (*tls.Config, error) {
+	if s.URLScheme() != SchemeHTTPS {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		ServerName:         s.TLS.ServerName,
+		InsecureSkipVerify: s.TLS.InsecureSkipVerify,
+	}
+	if s.TLS.CaFile != "" {
+		pem, err := ioutil.ReadFile(s.TLS.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to read server.tls.caFile, %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in server.tls.caFile %s", s.TLS.CaFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+		return nil, fmt.Errorf("server.tls.certFile and server.tls.keyFile should be set together")
+	}
+	if s.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.TLS.CertFile, s.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to load server.tls client certificate, %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,86 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+const (
+	// TypeMysql 基于北极星数据库 GET_LOCK 的选主
+	TypeMysql = "mysql"
+	// TypeKubernetes 基于 Kubernetes Lease 的选主
+	TypeKubernetes = "kubernetes"
+
+	defaultLockName      = "polaris-cleanup"
+	defaultLeaseDuration = 15
+)
+
+// Elector 选主
+type Elector interface {
+	// Run 参与选主，直到 ctx 结束
+	Run(ctx context.Context)
+	// IsLeader 当前副本是否为 leader
+	IsLeader() bool
+}
+
+// NewElector 根据配置创建选主
+func NewElector(cfg common.AppConfig) (Elector, error) {
+	le := cfg.LeaderElection
+	lockName := le.LockName
+	if lockName == "" {
+		lockName = defaultLockName
+	}
+	leaseDuration := time.Duration(le.LeaseDuration) * time.Second
+	if leaseDuration <= 0 {
+		leaseDuration = defaultLeaseDuration * time.Second
+	}
+	identity := Identity(cfg)
+
+	switch le.Type {
+	case "", TypeMysql:
+		db, err := store.MysqlDB()
+		if err != nil {
+			return nil, fmt.Errorf("leader election type %s is unavailable, %v", TypeMysql, err)
+		}
+		return newMysqlElector(db, lockName, identity, leaseDuration), nil
+	case TypeKubernetes:
+		return newKubernetesElector(cfg.Kubernetes, le.Namespace, lockName, identity, leaseDuration)
+	default:
+		return nil, fmt.Errorf("unknown leader election type: %s", le.Type)
+	}
+}
+
+// Identity 当前副本的标识，未配置 instanceId 时使用主机名
+func Identity(cfg common.AppConfig) string {
+	if cfg.InstanceId != "" {
+		return cfg.InstanceId
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return uuid.New().String()
+	}
+	return hostname
+}
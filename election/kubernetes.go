@@ -0,0 +1,113 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// kubernetesElector 基于 Kubernetes Lease 的选主
+type kubernetesElector struct {
+	config  leaderelection.LeaderElectionConfig
+	mutex   sync.RWMutex
+	elector *leaderelection.LeaderElector
+}
+
+func newKubernetesElector(k8sCfg common.Kubernetes, namespace, lockName, identity string,
+	leaseDuration time.Duration) (*kubernetesElector, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace of the lease is required")
+	}
+	restCfg, err := clientcmd.BuildConfigFromFlags("", k8sCfg.KubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("fail to build kubernetes config, err %v", err)
+	}
+	client, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("fail to create kubernetes client, err %v", err)
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: lockName},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   leaseDuration * 2 / 3,
+		RetryPeriod:     leaseDuration / 5,
+		ReleaseOnCancel: true,
+		Name:            lockName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ context.Context) {
+				glog.Infof("[Election] %s became leader of lease %s/%s", identity, namespace, lockName)
+			},
+			OnStoppedLeading: func() {
+				glog.Warningf("[Election] %s stopped leading lease %s/%s", identity, namespace, lockName)
+			},
+			OnNewLeader: func(leader string) {
+				glog.Infof("[Election] current leader of lease %s/%s is %s", namespace, lockName, leader)
+			},
+		},
+	}
+	// 提前校验配置
+	if _, err := leaderelection.NewLeaderElector(config); err != nil {
+		return nil, err
+	}
+	return &kubernetesElector{config: config}, nil
+}
+
+// IsLeader 当前副本是否为 leader
+func (e *kubernetesElector) IsLeader() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.elector != nil && e.elector.IsLeader()
+}
+
+// Run 参与选主，失去 leader 身份后重新参与，直到 ctx 结束
+func (e *kubernetesElector) Run(ctx context.Context) {
+	for {
+		elector, err := leaderelection.NewLeaderElector(e.config)
+		if err != nil {
+			glog.Errorf("[Election] create leader elector err: %s", err.Error())
+			return
+		}
+		e.mutex.Lock()
+		e.elector = elector
+		e.mutex.Unlock()
+
+		elector.Run(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
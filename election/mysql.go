@@ -0,0 +1,123 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// mysqlElector 基于 MySQL GET_LOCK 的选主，锁与数据库连接绑定，
+// leader 进程退出或者连接断开后 MySQL 会自动释放锁，其他副本即可接管
+type mysqlElector struct {
+	db       *sql.DB
+	lockName string
+	identity string
+	interval time.Duration
+	leader   int32
+}
+
+func newMysqlElector(db *sql.DB, lockName, identity string, leaseDuration time.Duration) *mysqlElector {
+	return &mysqlElector{
+		db:       db,
+		lockName: lockName,
+		identity: identity,
+		interval: leaseDuration / 3,
+	}
+}
+
+// IsLeader 当前副本是否为 leader
+func (e *mysqlElector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// Run 参与选主，直到 ctx 结束
+func (e *mysqlElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var conn *sql.Conn
+	for {
+		conn = e.campaign(ctx, conn)
+		select {
+		case <-ctx.Done():
+			if conn != nil {
+				e.release(conn)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign 尝试获取锁或者确认锁仍然被当前连接持有，返回持有锁的连接
+func (e *mysqlElector) campaign(ctx context.Context, conn *sql.Conn) *sql.Conn {
+	if conn != nil {
+		var holding sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", e.lockName).Scan(&holding)
+		if err == nil && holding.Valid && holding.Int64 == 1 {
+			return conn
+		}
+		glog.Warningf("[Election] %s lost leadership of %s, err: %v", e.identity, e.lockName, err)
+		e.setLeader(false)
+		_ = conn.Close()
+	}
+
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		glog.Errorf("[Election] get db conn err: %s", err.Error())
+		return nil
+	}
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", e.lockName).Scan(&acquired); err != nil {
+		glog.Errorf("[Election] get lock %s err: %s", e.lockName, err.Error())
+		_ = conn.Close()
+		return nil
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		_ = conn.Close()
+		return nil
+	}
+	glog.Infof("[Election] %s became leader of %s", e.identity, e.lockName)
+	e.setLeader(true)
+	return conn
+}
+
+// release 释放锁并关闭连接
+func (e *mysqlElector) release(conn *sql.Conn) {
+	e.setLeader(false)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", e.lockName); err != nil {
+		glog.Errorf("[Election] release lock %s err: %s", e.lockName, err.Error())
+	}
+	_ = conn.Close()
+	glog.Infof("[Election] %s released leadership of %s", e.identity, e.lockName)
+}
+
+func (e *mysqlElector) setLeader(leader bool) {
+	if leader {
+		atomic.StoreInt32(&e.leader, 1)
+	} else {
+		atomic.StoreInt32(&e.leader, 0)
+	}
+}
@@ -0,0 +1,257 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package guard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/metrics"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+const (
+	// ActionAbort 触发保护后任务失败
+	ActionAbort = "abort"
+	// ActionHold 触发保护后跳过本次删除，任务本身不算失败
+	ActionHold = "hold"
+
+	webhookTimeout = 5 * time.Second
+)
+
+// ErrHeld hold 模式下触发保护，任务应当直接结束且不做任何删除
+var ErrHeld = errors.New("deletion is held by the mass deletion guard")
+
+// Alert 触发保护时发送给 webhook 的内容
+type Alert struct {
+	Job        string    `json:"job"`
+	Action     string    `json:"action"`
+	Reason     string    `json:"reason"`
+	Candidates int       `json:"candidates"`
+	Total      int       `json:"total"`
+	Time       time.Time `json:"time"`
+}
+
+// Check 在删除服务实例前检查待删除的数量，没有超过阈值时返回 nil；
+// 超过阈值时 abort 模式返回错误，hold 模式记录原因到 report 后返回 ErrHeld
+func Check(cfg common.AppConfig, report *common.RunReport) error {
+	return NewChecker(cfg).Check(report)
+}
+
+// Checker 分页删除时在每页删除前检查累计的待删除数量，各服务的实例数只在第一次检查时统计，
+// 避免前面几页删除后实例数变少导致删除比例被高估
+type Checker struct {
+	cfg    common.AppConfig
+	counts map[store.ServiceKey]store.InstanceCount
+}
+
+// NewChecker 创建一次任务执行使用的检查器
+func NewChecker(cfg common.AppConfig) *Checker {
+	return &Checker{cfg: cfg}
+}
+
+// Enabled 是否需要检查，没有配置阈值或者开启了强制删除时不需要
+func (checker *Checker) Enabled() bool {
+	return enabled(checker.cfg.Guard) && !checker.cfg.Guard.Force
+}
+
+// Check 检查 report 中累计的待删除数量，返回值与 guard.Check 相同
+func (checker *Checker) Check(report *common.RunReport) error {
+	return checker.CheckCandidates(report, report.Candidates)
+}
+
+// CheckCandidates 检查 candidates 中的待删除数量，用于在删除第一页之前按全部待删除的实例检查，
+// 触发保护的原因记录到 report，返回值与 guard.Check 相同
+func (checker *Checker) CheckCandidates(report *common.RunReport, candidates []common.Candidate) error {
+	cfg := checker.cfg
+	guard := cfg.Guard
+	if !enabled(guard) {
+		return nil
+	}
+	if guard.Force {
+		glog.Warningf("[Guard][%s] force mode is on, mass deletion guard is skipped", report.Job)
+		return nil
+	}
+
+	perService := map[store.ServiceKey]int{}
+	num := 0
+	for _, c := range candidates {
+		if c.Kind != common.KindInstance {
+			continue
+		}
+		num++
+		perService[store.ServiceKey{Namespace: c.Namespace, Service: c.Service}]++
+	}
+	if num == 0 {
+		return nil
+	}
+
+	if checker.counts == nil {
+		counts, err := store.GetStore().CountServiceInstances()
+		if err != nil {
+			return fmt.Errorf("fail to count instances for mass deletion guard, err %v", err)
+		}
+		checker.counts = counts
+	}
+	counts := checker.counts
+	total := 0
+	for _, n := range counts {
+		total += n.Total
+	}
+
+	reasons := checkThresholds(guard, num, total, perService, counts)
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	action := guard.Action
+	if action != ActionHold {
+		action = ActionAbort
+	}
+	return checker.trip(report, action, strings.Join(reasons, "; "), num, total)
+}
+
+// Hold 无法得到完整的待删除数量时（如扫描超过 maxRunTime）按 hold 处理，本次执行不做任何删除，返回 ErrHeld
+func (checker *Checker) Hold(report *common.RunReport, reason string, candidates int) error {
+	return checker.trip(report, ActionHold, reason, candidates, 0)
+}
+
+// trip 记录触发保护的日志和监控指标并通知 webhook，hold 模式记录原因到 report 后返回 ErrHeld，否则返回错误
+func (checker *Checker) trip(report *common.RunReport, action, reason string, num, total int) error {
+	guard := checker.cfg.Guard
+	glog.Errorf("[Guard][%s] mass deletion guard tripped, action: %s, candidates: %d, total: %d, reason: %s",
+		report.Job, action, num, total, reason)
+	metrics.AddGuardTrip(report.Job, action)
+	notify(guard.Webhook, Alert{
+		Job:        report.Job,
+		Action:     action,
+		Reason:     reason,
+		Candidates: num,
+		Total:      total,
+		Time:       time.Now(),
+	})
+
+	if action == ActionHold {
+		report.Held = reason
+		return ErrHeld
+	}
+	return fmt.Errorf("mass deletion guard tripped, %s", reason)
+}
+
+func enabled(guard common.Guard) bool {
+	return guard.MaxDeleteNum > 0 || guard.MaxDeletePercent > 0 ||
+		guard.ServiceMaxDeleteNum > 0 || guard.ServiceMaxDeletePercent > 0
+}
+
+// checkThresholds 返回所有超过阈值的原因，全局的在前，服务按名称排序
+func checkThresholds(guard common.Guard, candidates, total int, perService map[store.ServiceKey]int,
+	counts map[store.ServiceKey]store.InstanceCount) []string {
+	var reasons []string
+	if guard.MaxDeleteNum > 0 && candidates > guard.MaxDeleteNum {
+		reasons = append(reasons, fmt.Sprintf("%d instances to delete exceeds maxDeleteNum %d",
+			candidates, guard.MaxDeleteNum))
+	}
+	if guard.MaxDeletePercent > 0 && exceedPercent(candidates, total, guard.MaxDeletePercent) {
+		reasons = append(reasons, fmt.Sprintf("%d of %d instances to delete exceeds maxDeletePercent %.2f%%",
+			candidates, total, guard.MaxDeletePercent))
+	}
+
+	keys := make([]store.ServiceKey, 0, len(perService))
+	for key := range perService {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Namespace != keys[j].Namespace {
+			return keys[i].Namespace < keys[j].Namespace
+		}
+		return keys[i].Service < keys[j].Service
+	})
+	for _, key := range keys {
+		num, serviceTotal := perService[key], counts[key].Total
+		if guard.ServiceMaxDeleteNum > 0 && num > guard.ServiceMaxDeleteNum {
+			reasons = append(reasons, fmt.Sprintf("service %s/%s: %d instances to delete exceeds "+
+				"serviceMaxDeleteNum %d", key.Namespace, key.Service, num, guard.ServiceMaxDeleteNum))
+		}
+		if guard.ServiceMaxDeletePercent > 0 && serviceTotal >= guard.ServiceMinInstances &&
+			exceedPercent(num, serviceTotal, guard.ServiceMaxDeletePercent) {
+			reasons = append(reasons, fmt.Sprintf("service %s/%s: %d of %d instances to delete exceeds "+
+				"serviceMaxDeletePercent %.2f%%", key.Namespace, key.Service, num, serviceTotal,
+				guard.ServiceMaxDeletePercent))
+		}
+	}
+	return reasons
+}
+
+// exceedPercent 待删除数量占总数的百分比是否超过阈值，总数为 0 时说明数据已经不一致，视为超过
+func exceedPercent(num, total int, percent float64) bool {
+	if total <= 0 {
+		return true
+	}
+	return float64(num)*100/float64(total) > percent
+}
+
+// notify 通知 webhook，失败只记录日志，不影响任务的执行结果
+func notify(webhook string, alert Alert) {
+	if webhook == "" {
+		return
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		glog.Errorf("[Guard] marshal alert err: %s", err.Error())
+		return
+	}
+	if err := sendAlert(webhook, body); err != nil {
+		glog.Errorf("[Guard] send alert to %s err: %s", webhookHost(webhook), err.Error())
+	}
+}
+
+// sendAlert 发送告警，返回的错误中不包含 webhook 地址
+func sendAlert(webhook string, body []byte) error {
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// url.Error 中带有完整的 webhook 地址
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookHost 日志中只输出 webhook 的 host，webhook 的路径和参数中通常带有 token
+func webhookHost(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil || u.Host == "" {
+		return "<invalid webhook>"
+	}
+	return u.Host
+}
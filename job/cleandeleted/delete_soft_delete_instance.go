@@ -20,12 +20,14 @@ package cleandeleted
 import (
 	"fmt"
 	//数据库操作相关库
-	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/audit"
 	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/protect"
 	"github.com/polarismesh/polaris-cleanup/store"
+	"github.com/polarismesh/polaris-cleanup/throttle"
 )
 
 // DeleteSoftDeleteInstanceJob
@@ -47,72 +49,124 @@ func (job *DeleteSoftDeleteInstanceJob) Destory() error {
 
 // CronSpec
 func (job *DeleteSoftDeleteInstanceJob) CronSpec() string {
-	return "0 0 1 * * ?"
+	return job.cfg.JobCronSpec(job.Name(), "0 0 1 * * ?")
 }
 
 // Execute
 func (job *DeleteSoftDeleteInstanceJob) Execute() func() {
 	return func() {
-		err := deleteSoftDeleteInstance(job.cfg)
-		if nil != err {
-			glog.Errorf("fail to soft  delete instance, err: %s", err.Error())
-		}
+		report, err := job.Run()
+		common.FinishRun(job.Name(), report, err)
 	}
 }
 
-func deleteSoftDeleteInstance(cfg common.AppConfig) error {
+// Run
+func (job *DeleteSoftDeleteInstanceJob) Run() (*common.RunReport, error) {
+	return deleteSoftDeleteInstance(job.Name(), job.cfg)
+}
+
+func deleteSoftDeleteInstance(name string, cfg common.AppConfig) (*common.RunReport, error) {
 	glog.Info("begin delete soft delete instance task")
-	dbSource := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", cfg.Store.DbUser, cfg.Store.DbPwd,
-		cfg.Store.DbHost, cfg.Store.DbPort, cfg.Store.DbName)
-	db, err := store.NewPolarisDB(dbSource)
+	db := store.GetStore()
+
+	limitTime, err := protect.QueryLimitTime(cfg.Cleanup.LimitedTime)
 	if err != nil {
-		glog.Errorf("[ERROR] new polaris db err: %s", err.Error())
+		return nil, err
 	}
-	defer db.Close()
-
-	instances, err := db.LoadAllInvalidInstances(cfg.Cleanup.LimitedTime, cfg.Cleanup.LimitedNum)
+	matcher, err := cfg.Selector.Compile()
 	if err != nil {
-		glog.Errorf("database load all invalid instances err: %s", err.Error())
-		return err
+		return nil, err
 	}
 
-	glog.Infof("instances count: %d", len(instances))
+	report := common.NewRunReport(name, cfg.DryRun)
+	// 多页共用一个限流器，按整次执行控制删除速率
+	t := throttle.New(name, cfg.Cleanup)
+	// 按主键分页查询，每页删除后再查询下一页，避免一次加载大量实例
+	it := store.NewInstanceIterator(func(lastID string, limit int) ([]*store.Instance, error) {
+		return db.LoadInvalidInstancesAfter(lastID, limitTime, limit)
+	}, cfg.Cleanup)
+	for {
+		instances, err := it.Next()
+		if err != nil {
+			glog.Errorf("database load invalid instances err: %s", err.Error())
+			return report, err
+		}
+		if len(instances) == 0 {
+			break
+		}
 
-	_ = iteratorInstance(db, cfg, instances)
-	glog.Infof("successful delete %v", instances)
+		instances = store.SelectInstances(matcher, instances)
+		var unexpired int
+		if instances, unexpired, err = protect.FilterInstances(instances, cfg.Cleanup.LimitedTime, report); err != nil {
+			return report, err
+		}
+		it.Release(unexpired)
+		ids := make([]string, 0, len(instances))
+		for _, ins := range instances {
+			report.AddCandidate(common.Candidate{
+				Kind:      common.KindInstance,
+				ID:        ins.ID,
+				Namespace: ins.Namespace,
+				Service:   ins.Service,
+				Host:      ins.Host,
+				Port:      ins.Port,
+				Reason:    fmt.Sprintf("soft deleted for more than %d minutes", cfg.Cleanup.LimitedTime),
+			})
+			ids = append(ids, ins.ID)
+		}
+		if cfg.DryRun || len(ids) == 0 {
+			continue
+		}
+		if err := iteratorInstance(db, t, cfg, limitTime, ids, report); err != nil {
+			return report, err
+		}
+		glog.Infof("successful delete %v", ids)
+	}
+
+	glog.Infof("scanned instances count: %d, candidates count: %d", it.Scanned(), len(report.Candidates))
 	glog.Info("delete soft delete instance task successful end")
-	return nil
+	return report, nil
 }
 
-func iteratorInstance(db *store.PolarisDB, cfg common.AppConfig, deleteInstances []string) error {
-
-	counter := 0
-	batchDeleteNum := cfg.Cleanup.BatchDeleteNum
-	if batchDeleteNum == 0 {
-		batchDeleteNum = 100
+func iteratorInstance(db store.Store, t *throttle.Throttle, cfg common.AppConfig, limitTime int,
+	deleteInstances []string, report *common.RunReport) error {
+	recheck := func(ids []string) ([]string, error) {
+		return db.RecheckInvalidInstances(ids, limitTime)
 	}
+	return batchClean(t, cfg, report, common.KindInstance, deleteInstances, recheck, db.CleanInvalidInstanceList)
+}
 
-	var deleteIns []string
-
-	for _, insId := range deleteInstances {
-		deleteIns = append(deleteIns, insId)
-		counter++
-		if counter >= batchDeleteNum {
-			err := db.CleanInvalidInstanceList(deleteIns)
+// auditClean 每批删除前先在主库上复查，再记录审计日志，审计日志记录失败时不做删除，返回这一批需要删除的数量
+func auditClean(report *common.RunReport, cfg common.AppConfig, kind string,
+	recheck func([]string) ([]string, error), clean func([]string) error) func([]string) (int, error) {
+	return func(ids []string) (int, error) {
+		if recheck != nil {
+			kept, err := recheck(ids)
 			if err != nil {
-				return fmt.Errorf("id:%s, fail to delete, err is %v", deleteIns, err)
+				return len(ids), err
+			}
+			if ids = report.SkipChanged(kind, ids, kept); len(ids) == 0 {
+				return 0, nil
 			}
-			counter = 0
-			deleteIns = nil
-			time.Sleep(time.Second)
 		}
-	}
-	if counter > 0 {
-		err := db.CleanInvalidInstanceList(deleteIns)
-		if err != nil {
-			return fmt.Errorf("id:%s, fail to delete, err is %v", deleteIns, err)
+		if err := audit.BeforeDelete(report.Job, audit.NewRequestID(cfg), report.CandidatesOf(kind, ids)); err != nil {
+			return len(ids), err
 		}
+		return len(ids), clean(ids)
 	}
+}
 
-	return nil
+// batchClean 按照 batchDeleteNum 分批并发复查、记录审计日志后清理，并统计删除结果，recheck 为 nil 时不复查
+func batchClean(t *throttle.Throttle, cfg common.AppConfig, report *common.RunReport, kind string, deleteIds []string,
+	recheck func([]string) ([]string, error), clean func([]string) error) error {
+	cleanBatch := auditClean(report, cfg, kind, recheck, clean)
+	return t.Run(deleteIds, func(batch []string) error {
+		num, err := cleanBatch(batch)
+		if err != nil {
+			report.AddFailed(kind, num)
+			return fmt.Errorf("id:%s, fail to delete, err is %v", batch, err)
+		}
+		report.AddDeleted(kind, num)
+		return nil
+	})
 }
@@ -0,0 +1,147 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cleandeleted
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/store"
+	"github.com/polarismesh/polaris-cleanup/throttle"
+)
+
+// DeleteSoftDeleteRulesJob 清理软删除的治理规则，如路由、限流、熔断规则
+type DeleteSoftDeleteRulesJob struct {
+	cfg common.AppConfig
+}
+
+func (job *DeleteSoftDeleteRulesJob) Init(cfg common.AppConfig) {
+	job.cfg = cfg
+}
+
+func (job *DeleteSoftDeleteRulesJob) Name() string {
+	return "DeleteSoftDeleteRules"
+}
+
+func (job *DeleteSoftDeleteRulesJob) Destory() error {
+	return nil
+}
+
+// CronSpec
+func (job *DeleteSoftDeleteRulesJob) CronSpec() string {
+	return job.cfg.JobCronSpec(job.Name(), "0 0 3 * * ?")
+}
+
+// Execute
+func (job *DeleteSoftDeleteRulesJob) Execute() func() {
+	return func() {
+		report, err := job.Run()
+		common.FinishRun(job.Name(), report, err)
+	}
+}
+
+// Run
+func (job *DeleteSoftDeleteRulesJob) Run() (*common.RunReport, error) {
+	return deleteSoftDeleteRules(job.Name(), job.cfg)
+}
+
+// ruleCleanups 获取要清理的规则类型及其清理参数，未配置时清理存储中有数据表的规则类型，
+// 老版本北极星没有 v2 路由、熔断等规则的表
+func ruleCleanups(db store.Store, name string, cfg common.AppConfig) ([]common.RuleCleanup, error) {
+	// 复制一份再补全清理参数，避免改写任务配置中的规则
+	rules := append([]common.RuleCleanup(nil), cfg.GetJobConfig(name).Rules...)
+	if len(rules) == 0 {
+		kinds, err := db.ExistingRuleKinds()
+		if err != nil {
+			return nil, err
+		}
+		if skipped := len(store.RuleKinds()) - len(kinds); skipped > 0 {
+			glog.Infof("[%s] %d rule kinds have no table and are skipped, cleaning %v", name, skipped, kinds)
+		}
+		for _, kind := range kinds {
+			rules = append(rules, common.RuleCleanup{Kind: kind})
+		}
+	}
+	for i := range rules {
+		rules[i].Cleanup = rules[i].Cleanup.Merge(cfg.Cleanup)
+	}
+	return rules, nil
+}
+
+func deleteSoftDeleteRules(name string, cfg common.AppConfig) (*common.RunReport, error) {
+	glog.Info("begin delete soft delete rules task")
+	db := store.GetStore()
+	// 治理规则不一定属于某个服务，不按 selector 过滤
+	if !cfg.Selector.IsEmpty() {
+		glog.Warningf("[%s] selector is not applied to governance rules", name)
+	}
+
+	rules, err := ruleCleanups(db, name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	report := common.NewRunReport(name, cfg.DryRun)
+	var errs []string
+	for _, rule := range rules {
+		// 单类规则清理失败不影响其他类型规则的清理
+		if err := deleteSoftDeleteRule(db, cfg, rule, report); err != nil {
+			glog.Errorf("fail to delete soft delete %s rules, err: %s", rule.Kind, err.Error())
+			errs = append(errs, fmt.Sprintf("%s: %v", rule.Kind, err))
+		}
+	}
+	if len(errs) > 0 {
+		return report, fmt.Errorf("fail to delete rules, %s", strings.Join(errs, "; "))
+	}
+	glog.Info("delete soft delete rules task successful end")
+	return report, nil
+}
+
+func deleteSoftDeleteRule(db store.Store, cfg common.AppConfig, rule common.RuleCleanup,
+	report *common.RunReport) error {
+	ids, err := db.LoadAllInvalidRules(rule.Kind, rule.LimitedTime, rule.LimitedNum)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("%s rules count: %d", rule.Kind, len(ids))
+
+	for _, id := range ids {
+		report.AddCandidate(common.Candidate{
+			Kind:   rule.Kind,
+			ID:     id,
+			Reason: fmt.Sprintf("soft deleted for more than %d minutes", rule.LimitedTime),
+		})
+	}
+	if cfg.DryRun {
+		return nil
+	}
+
+	ruleCfg := cfg
+	ruleCfg.Cleanup = rule.Cleanup
+	// 删除语句只删除软删除的规则，无需复查
+	err = batchClean(throttle.New(report.Job, rule.Cleanup), ruleCfg, report, rule.Kind, ids, nil, func(batch []string) error {
+		return db.CleanInvalidRuleList(rule.Kind, batch)
+	})
+	if err != nil {
+		return err
+	}
+	glog.Infof("successful delete %s rules %v", rule.Kind, ids)
+	return nil
+}
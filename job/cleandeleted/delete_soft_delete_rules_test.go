@@ -0,0 +1,79 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cleandeleted
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+// ruleKindStore 只实现规则类型的查询
+type ruleKindStore struct {
+	store.Store
+	kinds []string
+}
+
+func (r *ruleKindStore) ExistingRuleKinds() ([]string, error) {
+	return r.kinds, nil
+}
+
+func TestRuleCleanups(t *testing.T) {
+	const name = "DeleteSoftDeleteRules"
+	global := common.Cleanup{LimitedTime: 60, LimitedNum: 100}
+	tests := []struct {
+		name  string
+		rules []common.RuleCleanup
+		want  []common.RuleCleanup
+	}{
+		{
+			name:  "configured rules",
+			rules: []common.RuleCleanup{{Kind: "routing"}, {Kind: "ratelimit", Cleanup: common.Cleanup{LimitedTime: 10}}},
+			want: []common.RuleCleanup{
+				{Kind: "routing", Cleanup: global},
+				{Kind: "ratelimit", Cleanup: common.Cleanup{LimitedTime: 10, LimitedNum: 100}},
+			},
+		},
+		{
+			name: "existing rule kinds",
+			want: []common.RuleCleanup{{Kind: "circuitbreaker", Cleanup: global}, {Kind: "routing", Cleanup: global}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := common.AppConfig{
+				Cleanup: global,
+				OpenJob: []common.JobConfig{{Name: name, Rules: tt.rules}},
+			}
+			before := append([]common.RuleCleanup(nil), tt.rules...)
+			got, err := ruleCleanups(&ruleKindStore{kinds: []string{"circuitbreaker", "routing"}}, name, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ruleCleanups() = %+v, want %+v", got, tt.want)
+			}
+			// 补全的参数不能写回任务配置，否则热加载时会误判配置有变化
+			if after := cfg.OpenJob[0].Rules; !reflect.DeepEqual(after, before) {
+				t.Errorf("openJob rules changed to %+v, want %+v", after, before)
+			}
+		})
+	}
+}
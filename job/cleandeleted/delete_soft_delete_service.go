@@ -0,0 +1,115 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cleandeleted
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/protect"
+	"github.com/polarismesh/polaris-cleanup/store"
+	"github.com/polarismesh/polaris-cleanup/throttle"
+)
+
+// DeleteSoftDeleteServiceJob 清理软删除的服务
+type DeleteSoftDeleteServiceJob struct {
+	cfg common.AppConfig
+}
+
+func (job *DeleteSoftDeleteServiceJob) Init(cfg common.AppConfig) {
+	job.cfg = cfg
+}
+
+func (job *DeleteSoftDeleteServiceJob) Name() string {
+	return "DeleteSoftDeleteService"
+}
+
+func (job *DeleteSoftDeleteServiceJob) Destory() error {
+	return nil
+}
+
+// CronSpec
+func (job *DeleteSoftDeleteServiceJob) CronSpec() string {
+	return job.cfg.JobCronSpec(job.Name(), "0 30 1 * * ?")
+}
+
+// Execute
+func (job *DeleteSoftDeleteServiceJob) Execute() func() {
+	return func() {
+		report, err := job.Run()
+		common.FinishRun(job.Name(), report, err)
+	}
+}
+
+// Run
+func (job *DeleteSoftDeleteServiceJob) Run() (*common.RunReport, error) {
+	return deleteSoftDeleteService(job.Name(), job.cfg)
+}
+
+func deleteSoftDeleteService(name string, cfg common.AppConfig) (*common.RunReport, error) {
+	glog.Info("begin delete soft delete service task")
+	db := store.GetStore()
+
+	limitTime, err := protect.QueryLimitTime(cfg.Cleanup.LimitedTime)
+	if err != nil {
+		return nil, err
+	}
+	services, err := db.LoadAllInvalidServices(limitTime, cfg.Cleanup.LimitedNum)
+	if err != nil {
+		glog.Errorf("database load all invalid services err: %s", err.Error())
+		return nil, err
+	}
+
+	matcher, err := cfg.Selector.Compile()
+	if err != nil {
+		return nil, err
+	}
+	services = store.SelectServices(matcher, services)
+
+	report := common.NewRunReport(name, cfg.DryRun)
+	if services, err = protect.FilterServices(services, cfg.Cleanup.LimitedTime, report); err != nil {
+		return report, err
+	}
+	glog.Infof("services count: %d", len(services))
+
+	ids := make([]string, 0, len(services))
+	for _, svc := range services {
+		report.AddCandidate(common.Candidate{
+			Kind:      common.KindService,
+			ID:        svc.ID,
+			Namespace: svc.Namespace,
+			Service:   svc.Name,
+			Reason:    fmt.Sprintf("soft deleted for more than %d minutes", cfg.Cleanup.LimitedTime),
+		})
+		ids = append(ids, svc.ID)
+	}
+	if cfg.DryRun {
+		return report, nil
+	}
+
+	recheck := func(ids []string) ([]string, error) {
+		return db.RecheckInvalidServices(ids, limitTime)
+	}
+	if err := batchClean(throttle.New(name, cfg.Cleanup), cfg, report, common.KindService, ids, recheck, db.CleanInvalidServiceList); err != nil {
+		return report, err
+	}
+	glog.Infof("successful delete %v", ids)
+	glog.Info("delete soft delete service task successful end")
+	return report, nil
+}
@@ -18,58 +18,25 @@
 package cleanempty
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strconv"
+	"time"
 
 	"github.com/golang/glog"
-	"github.com/google/uuid"
+	"github.com/polarismesh/polaris-cleanup/audit"
 	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/polaris"
+	"github.com/polarismesh/polaris-cleanup/protect"
 )
 
-type GetServiceInfo struct {
-	Name                 string `json:"name"`
-	Namespace            string `json:"namespace"`
-	TotalInstanceCount   int32  `json:"total_instance_count"`
-	HealthyInstanceCount int32  `json:"healthy_instance_count"`
-}
-
-type GetServiesResponse struct {
-	Code     int              `json:"code"`
-	Info     string           `json:"info"`
-	Amount   int32            `json:"amount"`
-	Size     int32            `json:"size"`
-	Services []GetServiceInfo `json:"services"`
-}
-
-type DeleteServicesResponse struct {
-	Code      int                     `json:"code"`
-	Info      string                  `json:"info"`
-	Size      int32                   `json:"size"`
-	Responses []DeleteServiceResponse `json:"responses"`
-}
-
-type ServiceEntry struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-}
-
-type DeleteServiceResponse struct {
-	Code    int          `json:"code"`
-	Info    string       `json:"info"`
-	Service ServiceEntry `json:"service"`
-}
-
 // DeleteEmptyServiceJob
 type DeleteEmptyServiceJob struct {
-	cfg common.AppConfig
+	cfg    common.AppConfig
+	client polaris.API
 }
 
 func (job *DeleteEmptyServiceJob) Init(cfg common.AppConfig) {
 	job.cfg = cfg
+	job.client = polaris.NewAPI(cfg.Server)
 }
 
 func (job *DeleteEmptyServiceJob) Name() string {
@@ -77,29 +44,56 @@ func (job *DeleteEmptyServiceJob) Name() string {
 }
 
 func (job *DeleteEmptyServiceJob) Destory() error {
-	return nil
+	if job.client == nil {
+		return nil
+	}
+	return job.client.Close()
 }
 
 // CronSpec
 func (job *DeleteEmptyServiceJob) CronSpec() string {
-	return "0 0 * * * *"
+	return job.cfg.JobCronSpec(job.Name(), "0 0 * * * *")
 }
 
 // Execute
 func (job *DeleteEmptyServiceJob) Execute() func() {
 	return func() {
-		job.deleteEmptyService(job.cfg)
+		report, err := job.Run()
+		common.FinishRun(job.Name(), report, err)
 	}
 }
 
-func (job *DeleteEmptyServiceJob) deleteEmptyService(cfg common.AppConfig) {
+// Run
+func (job *DeleteEmptyServiceJob) Run() (*common.RunReport, error) {
+	return job.deleteEmptyService(job.cfg)
+}
+
+func (job *DeleteEmptyServiceJob) deleteEmptyService(cfg common.AppConfig) (*common.RunReport, error) {
 	emptyServices, err := job.getEmptyAutoCreatedServices()
 	if err != nil {
-		glog.Errorf("[DeleteEmptyService] fail to get services, %v", err)
-		return
+		return nil, fmt.Errorf("fail to get services, %v", err)
 	}
 	glog.Infof("[DeleteEmptyService] empty autoCreated services total count %d", len(emptyServices))
 
+	report := common.NewRunReport(job.Name(), cfg.DryRun)
+	matcher, err := cfg.Selector.Compile()
+	if err != nil {
+		return nil, err
+	}
+	emptyServices = selectServices(matcher, emptyServices)
+	emptyServices = filterProtectedServices(emptyServices, report)
+	for _, svc := range emptyServices {
+		report.AddCandidate(common.Candidate{
+			Kind:      common.KindService,
+			Namespace: svc.Namespace,
+			Service:   svc.Name,
+			Reason:    "auto created service without instance",
+		})
+	}
+	if cfg.DryRun {
+		return report, nil
+	}
+
 	deleteBatchSize := 10
 	for i := 0; i < len(emptyServices); i += deleteBatchSize {
 		j := i + deleteBatchSize
@@ -108,139 +102,107 @@ func (job *DeleteEmptyServiceJob) deleteEmptyService(cfg common.AppConfig) {
 		}
 
 		entries := convertServiceEntries(emptyServices[i:j])
-		err := job.sendDeleteServicesRequest(entries)
+		requestID := audit.NewRequestID(cfg)
+		if err := audit.BeforeDelete(job.Name(), requestID, report.Candidates[i:j]); err != nil {
+			glog.Errorf("[DeleteEmptyService] failed to audit services, %v, %v", entries, err)
+			report.AddFailed(common.KindService, len(entries))
+			continue
+		}
+		deleted, err := job.sendDeleteServicesRequest(entries, requestID)
 		if err != nil {
 			glog.Errorf("[DeleteEmptyService] failed to delete services, %v, %v", entries, err)
 		}
+		report.AddDeleted(common.KindService, deleted)
+		report.AddFailed(common.KindService, len(entries)-deleted)
 	}
+	return report, nil
 }
 
-func convertServiceEntries(infos []GetServiceInfo) []ServiceEntry {
-	var entries = make([]ServiceEntry, len(infos))
-	for i, info := range infos {
-		entries[i] = ServiceEntry{Namespace: info.Namespace, Name: info.Name}
+// selectServices 过滤出在清理范围内的服务
+func selectServices(m *common.Matcher, services []polaris.Service) []polaris.Service {
+	out := make([]polaris.Service, 0, len(services))
+	for _, svc := range services {
+		if m.Match(svc.Namespace, svc.Name, "") {
+			out = append(out, svc)
+		}
 	}
-	return entries[:]
+	return out
 }
 
-func (job *DeleteEmptyServiceJob) sendDeleteServicesRequest(entries []ServiceEntry) error {
-	url := fmt.Sprintf("http://%s/naming/v1/services/delete", job.cfg.Server.ChooseOneEndpoint())
-
-	reqBody, err := json.Marshal(entries)
-	if err != nil {
-		return err
+// filterProtectedServices 过滤掉带有保护标签或者未超过 ttl 标签的服务并记录到 report 中，
+// 空服务本身不受 deleteLimitedTime 限制
+func filterProtectedServices(services []polaris.Service, report *common.RunReport) []polaris.Service {
+	out := make([]polaris.Service, 0, len(services))
+	for _, svc := range services {
+		labels := protect.Parse(svc.Metadata)
+		skipped := common.Candidate{
+			Kind:      common.KindService,
+			Namespace: svc.Namespace,
+			Service:   svc.Name,
+		}
+		switch age := serviceAge(svc); {
+		case labels.Protect:
+			skipped.Reason = protect.ProtectedReason
+		case !labels.Expired(age, 0):
+			skipped.Reason = labels.UnexpiredReason(age, 0)
+		default:
+			out = append(out, svc)
+			continue
+		}
+		report.AddSkipped(skipped)
 	}
+	return out
+}
 
-	client := &http.Client{}
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+// serviceAge 服务最近一次变更距今的时间，无法解析时返回 0
+func serviceAge(svc polaris.Service) time.Duration {
+	mtime, err := time.ParseInLocation("2006-01-02 15:04:05", svc.Mtime, time.Local)
 	if err != nil {
-		return err
+		return 0
 	}
+	return time.Since(mtime)
+}
 
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Request-Id", job.cfg.Server.RequestPrefix+uuid.New().String())
-	request.Header.Set("Staffname", "空服务定时自动删除")
-	request.Header.Set("X-Polaris-Token", job.cfg.Server.AuthToken)
-	resp, err := client.Do(request)
-	if err != nil {
-		return err
+func convertServiceEntries(infos []polaris.Service) []polaris.ServiceKey {
+	var entries = make([]polaris.ServiceKey, len(infos))
+	for i, info := range infos {
+		entries[i] = polaris.ServiceKey{Namespace: info.Namespace, Name: info.Name}
 	}
-	defer resp.Body.Close()
+	return entries[:]
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+func (job *DeleteEmptyServiceJob) sendDeleteServicesRequest(entries []polaris.ServiceKey, requestID string) (int, error) {
+	responses, err := job.client.DeleteServices(requestID, "空服务定时自动删除", entries)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	var response DeleteServicesResponse
-	if err = json.Unmarshal(body, &response); err != nil {
-		return err
-	}
-
-	for _, singleResp := range response.Responses {
-		if singleResp.Code != 200000 {
+	deleted := 0
+	for _, singleResp := range responses {
+		if singleResp.Code != polaris.CodeSuccess {
 			glog.Warningf("[DeleteEmptyService] fail to delete service, %s %s, code: %d, info:%s",
 				singleResp.Service.Namespace, singleResp.Service.Name, singleResp.Code, singleResp.Info)
 		} else {
 			glog.Infof("[DeleteEmptyService] %s %s deleted",
 				singleResp.Service.Namespace, singleResp.Service.Name)
+			deleted++
 
 		}
 
 	}
-	return nil
+	return deleted, nil
 }
 
-func (job *DeleteEmptyServiceJob) sendGetServicesRequest(query map[string]string) (*GetServiesResponse, error) {
-	url := fmt.Sprintf("http://%s/naming/v1/services", job.cfg.Server.ChooseOneEndpoint())
-
-	client := &http.Client{}
-	request, err := http.NewRequest("GET", url, nil)
+func (job *DeleteEmptyServiceJob) getEmptyAutoCreatedServices() ([]polaris.Service, error) {
+	services, err := job.client.ListServices(map[string]string{"internal-auto-created": "true"})
 	if err != nil {
 		return nil, err
 	}
-
-	q := request.URL.Query()
-	for k, v := range query {
-		q.Add(k, v)
-	}
-	request.URL.RawQuery = q.Encode()
-
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Request-Id", job.cfg.Server.RequestPrefix+uuid.New().String())
-	request.Header.Set("Staffname", "空服务定时自动查询")
-	request.Header.Set("X-Polaris-Token", job.cfg.Server.AuthToken)
-	resp, err := client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("fail to read response, err %v", err)
-	}
-
-	var response GetServiesResponse
-	if err = json.Unmarshal(body, &response); err != nil {
-		return nil, err
-	}
-
-	if response.Code != 200000 {
-		return nil, fmt.Errorf("fail to get services, query:%v, code:%d, info:%s",
-			query, response.Code, response.Info)
-	}
-
-	return &response, nil
-}
-
-func (job *DeleteEmptyServiceJob) getEmptyAutoCreatedServices() ([]GetServiceInfo, error) {
-	var emtpyServices []GetServiceInfo
-	var offset int32 = 0
-	var query = map[string]string{
-		"keys":   "internal-auto-created",
-		"values": "true",
-		"limit":  "100",
-		"offset": strconv.Itoa(int(offset))}
-
-	for {
-		resp, err := job.sendGetServicesRequest(query)
-		if err != nil {
-			return nil, err
-		}
-
-		for _, entry := range resp.Services {
-			if entry.TotalInstanceCount == 0 {
-				emtpyServices = append(emtpyServices, entry)
-			}
-		}
-
-		nextOffset := offset + resp.Size
-		if nextOffset >= resp.Amount {
-			break
+	var emtpyServices []polaris.Service
+	for _, entry := range services {
+		if entry.TotalInstanceCount == 0 {
+			emtpyServices = append(emtpyServices, entry)
 		}
-		offset = nextOffset
-		query["offset"] = strconv.Itoa(int(offset))
 	}
 	return emtpyServices, nil
 }
@@ -0,0 +1,63 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cleanempty
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/polaris"
+	"github.com/polarismesh/polaris-cleanup/protect"
+)
+
+func TestFilterProtectedServices(t *testing.T) {
+	mtime := func(age time.Duration) string {
+		return time.Now().Add(-age).Format("2006-01-02 15:04:05")
+	}
+	services := []polaris.Service{
+		{Name: "plain", Namespace: "Test", Mtime: mtime(time.Minute)},
+		{Name: "protected", Namespace: "Test", Mtime: mtime(time.Hour),
+			Metadata: map[string]string{protect.LabelProtect: "true"}},
+		{Name: "expired", Namespace: "Test", Mtime: mtime(2 * time.Hour),
+			Metadata: map[string]string{protect.LabelTTL: "1h"}},
+		{Name: "unexpired", Namespace: "Test", Mtime: mtime(30 * time.Minute),
+			Metadata: map[string]string{protect.LabelTTL: "1h"}},
+	}
+	report := common.NewRunReport("DeleteEmptyService", true)
+	out := filterProtectedServices(services, report)
+
+	var kept []string
+	for _, svc := range out {
+		kept = append(kept, svc.Name)
+	}
+	// 空服务本身不受 deleteLimitedTime 限制，没有 ttl 标签时直接清理
+	if want := []string{"plain", "expired"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	skipped := map[string]string{}
+	for _, c := range report.Skipped {
+		skipped[c.Service] = c.Reason
+	}
+	if len(skipped) != 2 || skipped["protected"] != protect.ProtectedReason ||
+		!strings.HasPrefix(skipped["unexpired"], "ttl not expired") {
+		t.Errorf("skipped %v, want protected and unexpired", skipped)
+	}
+}
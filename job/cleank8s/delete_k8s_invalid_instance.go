@@ -0,0 +1,306 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cleank8s
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/audit"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/guard"
+	"github.com/polarismesh/polaris-cleanup/polaris"
+	"github.com/polarismesh/polaris-cleanup/protect"
+	"github.com/polarismesh/polaris-cleanup/store"
+	"github.com/polarismesh/polaris-cleanup/throttle"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// listPageSize 分页查询 pod 和 node 的每页数量
+	listPageSize = 500
+)
+
+// DeleteK8sInvalidInstanceJob 清理 host 不属于 Kubernetes 中运行状态 pod 的实例
+type DeleteK8sInvalidInstanceJob struct {
+	cfg           common.AppConfig
+	client        kubernetes.Interface
+	polarisClient polaris.API
+}
+
+func (job *DeleteK8sInvalidInstanceJob) Init(cfg common.AppConfig) {
+	job.cfg = cfg
+	job.polarisClient = polaris.NewAPI(cfg.Server)
+}
+
+func (job *DeleteK8sInvalidInstanceJob) Name() string {
+	return "DeleteK8sInvalidInstance"
+}
+
+func (job *DeleteK8sInvalidInstanceJob) Destory() error {
+	if job.polarisClient == nil {
+		return nil
+	}
+	return job.polarisClient.Close()
+}
+
+// CronSpec
+func (job *DeleteK8sInvalidInstanceJob) CronSpec() string {
+	return job.cfg.JobCronSpec(job.Name(), "0 */30 * * * *")
+}
+
+// Execute
+func (job *DeleteK8sInvalidInstanceJob) Execute() func() {
+	return func() {
+		report, err := job.Run()
+		common.FinishRun(job.Name(), report, err)
+	}
+}
+
+// Run
+func (job *DeleteK8sInvalidInstanceJob) Run() (*common.RunReport, error) {
+	client, err := job.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return job.deleteInvalidInstances(client, job.cfg)
+}
+
+// getClient 创建 Kubernetes 客户端，kubeConfig 为空时使用 in-cluster 配置
+func (job *DeleteK8sInvalidInstanceJob) getClient() (kubernetes.Interface, error) {
+	if job.client != nil {
+		return job.client, nil
+	}
+	restCfg, err := clientcmd.BuildConfigFromFlags("", job.cfg.Kubernetes.KubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("fail to build kubernetes config, err %v", err)
+	}
+	client, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("fail to create kubernetes client, err %v", err)
+	}
+	job.client = client
+	return client, nil
+}
+
+func (job *DeleteK8sInvalidInstanceJob) deleteInvalidInstances(client kubernetes.Interface,
+	cfg common.AppConfig) (*common.RunReport, error) {
+	glog.Info("[DeleteK8sInvalidInstance] begin delete k8s invalid instance task")
+	if len(cfg.Kubernetes.Namespaces) == 0 {
+		return nil, fmt.Errorf("no kubernetes namespace mapping configured")
+	}
+
+	var nodeIPs map[string]bool
+	if cfg.Kubernetes.CheckNodes {
+		var err error
+		if nodeIPs, err = listNodeIPs(client); err != nil {
+			return nil, err
+		}
+	}
+
+	limitTime, err := protect.QueryLimitTime(cfg.Cleanup.LimitedTime)
+	if err != nil {
+		return nil, err
+	}
+
+	report := common.NewRunReport(job.Name(), cfg.DryRun)
+	var errs []string
+	var deleteInstances []string
+	for _, mapping := range cfg.Kubernetes.Namespaces {
+		// 单个命名空间处理失败不影响其他命名空间
+		ids, err := job.findNamespaceInstances(client, cfg, limitTime, mapping, nodeIPs, report)
+		if err != nil {
+			glog.Errorf("[DeleteK8sInvalidInstance] namespace %s -> %s, err: %s",
+				mapping.Polaris, mapping.Kubernetes, err.Error())
+			errs = append(errs, fmt.Sprintf("%s: %v", mapping.Polaris, err))
+			continue
+		}
+		deleteInstances = append(deleteInstances, ids...)
+	}
+
+	// 所有命名空间的待删除实例一起做批量删除保护检查
+	if !cfg.DryRun && len(deleteInstances) > 0 {
+		if err := guard.Check(cfg, report); err != nil {
+			if err == guard.ErrHeld {
+				return report, nil
+			}
+			return report, err
+		}
+		if err := job.deleteInstances(cfg, limitTime, deleteInstances, report); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return report, fmt.Errorf("fail to delete instances, %s", strings.Join(errs, "; "))
+	}
+	glog.Info("[DeleteK8sInvalidInstance] delete k8s invalid instance task successful end")
+	return report, nil
+}
+
+// findNamespaceInstances 找出命名空间下 host 不属于运行中 pod 的实例，记录到 report 中并返回它们的 ID
+func (job *DeleteK8sInvalidInstanceJob) findNamespaceInstances(client kubernetes.Interface, cfg common.AppConfig,
+	limitTime int, mapping common.NamespaceMapping, nodeIPs map[string]bool,
+	report *common.RunReport) ([]string, error) {
+	podIPs, err := listRunningPodIPs(client, mapping.Kubernetes)
+	if err != nil {
+		return nil, err
+	}
+	// 没有任何运行中的 pod 时，更可能是映射配置或者权限有误，此时不做删除
+	if len(podIPs) == 0 {
+		return nil, fmt.Errorf("no running pod found in kubernetes namespace %s", mapping.Kubernetes)
+	}
+
+	instances, err := store.GetStore().LoadNamespaceInstances(mapping.Polaris, limitTime, cfg.Cleanup.LimitedNum)
+	if err != nil {
+		return nil, err
+	}
+
+	matcher, err := cfg.Selector.Compile()
+	if err != nil {
+		return nil, err
+	}
+	invalid := make([]*store.Instance, 0)
+	for _, ins := range store.SelectInstances(matcher, instances) {
+		if !podIPs[ins.Host] && !nodeIPs[ins.Host] {
+			invalid = append(invalid, ins)
+		}
+	}
+	if invalid, _, err = protect.FilterInstances(invalid, cfg.Cleanup.LimitedTime, report); err != nil {
+		return nil, err
+	}
+
+	var deleteInstances []string
+	for _, ins := range invalid {
+		report.AddCandidate(common.Candidate{
+			Kind:      common.KindInstance,
+			ID:        ins.ID,
+			Namespace: ins.Namespace,
+			Service:   ins.Service,
+			Host:      ins.Host,
+			Port:      ins.Port,
+			Reason:    fmt.Sprintf("host is not a running pod in kubernetes namespace %s", mapping.Kubernetes),
+		})
+		deleteInstances = append(deleteInstances, ins.ID)
+	}
+	return deleteInstances, nil
+}
+
+// deleteInstances 分批并发在主库上复查、记录审计日志后删除实例
+func (job *DeleteK8sInvalidInstanceJob) deleteInstances(cfg common.AppConfig, limitTime int, deleteInstances []string,
+	report *common.RunReport) error {
+	return throttle.New(job.Name(), cfg.Cleanup).Run(deleteInstances, func(batch []string) error {
+		ids, err := recheckInstances(batch, limitTime, report)
+		if err != nil {
+			report.AddFailed(common.KindInstance, len(batch))
+			return fmt.Errorf("id:%s, fail to recheck, err is %v", batch, err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		requestID := audit.NewRequestID(cfg)
+		err = audit.BeforeDelete(job.Name(), requestID, report.CandidatesOf(common.KindInstance, ids))
+		if err == nil {
+			err = job.sendDeleteInstancesRequest(ids, requestID)
+		}
+		if err != nil {
+			report.AddFailed(common.KindInstance, len(ids))
+			return fmt.Errorf("id:%s, fail to delete, err is %v", ids, err)
+		}
+		report.AddDeleted(common.KindInstance, len(ids))
+		return nil
+	})
+}
+
+// recheckInstances 按命名空间在主库上复查一批实例仍未删除并且没有变更过，返回仍需删除的实例 ID
+func recheckInstances(ids []string, limitTime int, report *common.RunReport) ([]string, error) {
+	byNamespace := map[string][]string{}
+	for _, c := range report.CandidatesOf(common.KindInstance, ids) {
+		byNamespace[c.Namespace] = append(byNamespace[c.Namespace], c.ID)
+	}
+	kept := make([]string, 0, len(ids))
+	for namespace, namespaceIds := range byNamespace {
+		out, err := store.GetStore().RecheckNamespaceInstances(namespace, namespaceIds, limitTime)
+		if err != nil {
+			return nil, err
+		}
+		kept = append(kept, out...)
+	}
+	return report.SkipChanged(common.KindInstance, ids, kept), nil
+}
+
+// listRunningPodIPs 获取命名空间下所有运行中 pod 的 IP
+func listRunningPodIPs(client kubernetes.Interface, namespace string) (map[string]bool, error) {
+	ips := map[string]bool{}
+	opts := metav1.ListOptions{Limit: listPageSize}
+	for {
+		pods, err := client.CoreV1().Pods(namespace).List(opts)
+		if err != nil {
+			return nil, fmt.Errorf("fail to list pods in %s, err %v", namespace, err)
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+			if pod.Status.PodIP != "" {
+				ips[pod.Status.PodIP] = true
+			}
+			for _, podIP := range pod.Status.PodIPs {
+				ips[podIP.IP] = true
+			}
+		}
+		if pods.Continue == "" {
+			return ips, nil
+		}
+		opts.Continue = pods.Continue
+	}
+}
+
+// listNodeIPs 获取集群中所有节点的 IP
+func listNodeIPs(client kubernetes.Interface) (map[string]bool, error) {
+	ips := map[string]bool{}
+	opts := metav1.ListOptions{Limit: listPageSize}
+	for {
+		nodes, err := client.CoreV1().Nodes().List(opts)
+		if err != nil {
+			return nil, fmt.Errorf("fail to list nodes, err %v", err)
+		}
+		for _, node := range nodes.Items {
+			for _, addr := range node.Status.Addresses {
+				if addr.Type == corev1.NodeInternalIP || addr.Type == corev1.NodeExternalIP {
+					ips[addr.Address] = true
+				}
+			}
+		}
+		if nodes.Continue == "" {
+			return ips, nil
+		}
+		opts.Continue = nodes.Continue
+	}
+}
+
+func (job *DeleteK8sInvalidInstanceJob) sendDeleteInstancesRequest(ids []string, requestID string) error {
+	if err := job.polarisClient.DeleteInstances(requestID, "K8s无效实例定时自动删除", ids); err != nil {
+		return fmt.Errorf("fail to delete the instance, id:%s, %v", ids, err)
+	}
+	glog.Infof("[DeleteK8sInvalidInstance] success to delete the instance, id:%s", ids)
+	return nil
+}
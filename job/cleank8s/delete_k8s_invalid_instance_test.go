@@ -0,0 +1,222 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cleank8s
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/polaris"
+	"github.com/polarismesh/polaris-cleanup/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeStore 只实现任务用到的存储操作，调用其他操作时 panic
+type fakeStore struct {
+	store.Store
+	instances map[string][]*store.Instance
+	loaded    []string
+}
+
+func (f *fakeStore) LoadNamespaceInstances(namespace string, _, _ int) ([]*store.Instance, error) {
+	f.loaded = append(f.loaded, namespace)
+	return f.instances[namespace], nil
+}
+
+func (f *fakeStore) RecheckNamespaceInstances(_ string, ids []string, _ int) ([]string, error) {
+	return ids, nil
+}
+
+func (f *fakeStore) LoadInstanceLabels(_, _ []string) (map[string]map[string]string, error) {
+	return nil, nil
+}
+
+func (f *fakeStore) LoadLabelValues(string) ([]string, error) {
+	return nil, nil
+}
+
+// fakeAPI 记录反注册的实例
+type fakeAPI struct {
+	polaris.API
+	lock    sync.Mutex
+	deleted []string
+}
+
+func (f *fakeAPI) DeleteInstances(_, _ string, ids []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.deleted = append(f.deleted, ids...)
+	return nil
+}
+
+func pod(namespace, name string, phase corev1.PodPhase, ips ...string) runtime.Object {
+	p := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+	if len(ips) > 0 {
+		p.Status.PodIP = ips[0]
+	}
+	for _, ip := range ips {
+		p.Status.PodIPs = append(p.Status.PodIPs, corev1.PodIP{IP: ip})
+	}
+	return p
+}
+
+func node(name string, addrs ...corev1.NodeAddress) runtime.Object {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Addresses: addrs},
+	}
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestListRunningPodIPs(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		pod("default", "running", corev1.PodRunning, "10.0.0.1"),
+		pod("default", "dual-stack", corev1.PodRunning, "10.0.0.2", "fd00::2"),
+		pod("default", "pending", corev1.PodPending, "10.0.0.3"),
+		pod("default", "succeeded", corev1.PodSucceeded, "10.0.0.4"),
+		pod("default", "failed", corev1.PodFailed, "10.0.0.5"),
+		pod("default", "no-ip", corev1.PodRunning),
+		pod("other", "other", corev1.PodRunning, "10.0.1.1"),
+	)
+
+	tests := []struct {
+		namespace string
+		want      []string
+	}{
+		{namespace: "default", want: []string{"10.0.0.1", "10.0.0.2", "fd00::2"}},
+		{namespace: "other", want: []string{"10.0.1.1"}},
+		{namespace: "empty", want: []string{}},
+	}
+	for _, tt := range tests {
+		ips, err := listRunningPodIPs(client, tt.namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keys(ips); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("namespace %s: pod IPs = %v, want %v", tt.namespace, got, tt.want)
+		}
+	}
+}
+
+func TestListNodeIPs(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		node("node-1",
+			corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.0.1"},
+			corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "1.1.1.1"},
+			corev1.NodeAddress{Type: corev1.NodeHostName, Address: "node-1"},
+		),
+		node("node-2", corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.0.2"}),
+	)
+	ips, err := listNodeIPs(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.1.1.1", "192.168.0.1", "192.168.0.2"}
+	if got := keys(ips); !reflect.DeepEqual(got, want) {
+		t.Fatalf("node IPs = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteInvalidInstancesCheckNodes(t *testing.T) {
+	objects := []runtime.Object{
+		pod("default", "running", corev1.PodRunning, "10.0.0.1"),
+		node("node-1", corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.0.1"}),
+	}
+	instances := []*store.Instance{
+		{ID: "pod", Namespace: "Test", Service: "svc", Host: "10.0.0.1"},
+		{ID: "host-network", Namespace: "Test", Service: "svc", Host: "192.168.0.1"},
+		{ID: "gone", Namespace: "Test", Service: "svc", Host: "10.0.0.9"},
+	}
+
+	tests := []struct {
+		name       string
+		checkNodes bool
+		want       []string
+	}{
+		{name: "pods only", checkNodes: false, want: []string{"gone", "host-network"}},
+		{name: "check nodes", checkNodes: true, want: []string{"gone"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.SetStore(&fakeStore{instances: map[string][]*store.Instance{"Test": instances}})
+			defer store.SetStore(nil)
+			api := &fakeAPI{}
+			job := &DeleteK8sInvalidInstanceJob{polarisClient: api}
+			cfg := common.AppConfig{Kubernetes: common.Kubernetes{
+				CheckNodes: tt.checkNodes,
+				Namespaces: []common.NamespaceMapping{{Polaris: "Test", Kubernetes: "default"}},
+			}}
+
+			report, err := job.deleteInvalidInstances(fake.NewSimpleClientset(objects...), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(api.deleted)
+			if !reflect.DeepEqual(api.deleted, tt.want) {
+				t.Fatalf("deleted = %v, want %v", api.deleted, tt.want)
+			}
+			if report.Deleted != len(tt.want) {
+				t.Fatalf("report deleted = %d, want %d", report.Deleted, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestDeleteInvalidInstancesNoRunningPod(t *testing.T) {
+	db := &fakeStore{instances: map[string][]*store.Instance{
+		"Test": {{ID: "ins-1", Namespace: "Test", Service: "svc", Host: "10.0.0.1"}},
+	}}
+	store.SetStore(db)
+	defer store.SetStore(nil)
+	api := &fakeAPI{}
+	job := &DeleteK8sInvalidInstanceJob{polarisClient: api}
+	cfg := common.AppConfig{Kubernetes: common.Kubernetes{
+		Namespaces: []common.NamespaceMapping{{Polaris: "Test", Kubernetes: "default"}},
+	}}
+	// 只有未运行的 pod，可能是映射配置错误，不能把命名空间下的实例都删除
+	client := fake.NewSimpleClientset(pod("default", "pending", corev1.PodPending, "10.0.0.1"))
+
+	report, err := job.deleteInvalidInstances(client, cfg)
+	if err == nil || !strings.Contains(err.Error(), "no running pod found in kubernetes namespace default") {
+		t.Fatalf("error = %v", err)
+	}
+	if len(db.loaded) != 0 {
+		t.Fatalf("instances of %v loaded", db.loaded)
+	}
+	if len(api.deleted) != 0 || len(report.Candidates) != 0 {
+		t.Fatalf("deleted %v, candidates %v", api.deleted, report.Candidates)
+	}
+}
@@ -18,18 +18,15 @@
 package cleanunhealthy
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"math/rand"
 
-	"io/ioutil"
-	"net/http"
-	"time"
-
-	"github.com/google/uuid"
+	"github.com/polarismesh/polaris-cleanup/audit"
 	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/guard"
+	"github.com/polarismesh/polaris-cleanup/polaris"
+	"github.com/polarismesh/polaris-cleanup/protect"
 	"github.com/polarismesh/polaris-cleanup/store"
+	"github.com/polarismesh/polaris-cleanup/throttle"
 
 	//数据库操作相关库
 	_ "github.com/go-sql-driver/mysql"
@@ -38,11 +35,13 @@ import (
 
 // DeleteUnHealthyInstanceJob
 type DeleteUnHealthyInstanceJob struct {
-	cfg common.AppConfig
+	cfg    common.AppConfig
+	client polaris.API
 }
 
 func (job *DeleteUnHealthyInstanceJob) Init(cfg common.AppConfig) {
 	job.cfg = cfg
+	job.client = polaris.NewAPI(cfg.Server)
 }
 
 func (job *DeleteUnHealthyInstanceJob) Name() string {
@@ -50,25 +49,28 @@ func (job *DeleteUnHealthyInstanceJob) Name() string {
 }
 
 func (job *DeleteUnHealthyInstanceJob) Destory() error {
-	return nil
+	if job.client == nil {
+		return nil
+	}
+	return job.client.Close()
 }
 
 // CronSpec
 func (job *DeleteUnHealthyInstanceJob) CronSpec() string {
-	return "0 0 2 * * ?"
+	return job.cfg.JobCronSpec(job.Name(), "0 0 2 * * ?")
 }
 
 // Execute
 func (job *DeleteUnHealthyInstanceJob) Execute() func() {
 	return func() {
-		job.deleteUnHealthInstance(job.cfg)
+		report, err := job.Run()
+		common.FinishRun(job.Name(), report, err)
 	}
 }
 
-// Response 删除服务实例接口的回复
-type Response struct {
-	Code int    `json:"code"`
-	Info string `json:"info"`
+// Run
+func (job *DeleteUnHealthyInstanceJob) Run() (*common.RunReport, error) {
+	return job.deleteUnHealthInstance(job.cfg)
 }
 
 // PolarisInstance 用来进行序列化和反序列化的实例结构体
@@ -95,132 +97,225 @@ type InstanceHeartbeatInfo struct {
 	PolarisInstance PolarisInstance `json:"instance"`
 }
 
-// ResponseParam 删除服务实例接口的请求参数
-type ResponseParam struct {
-	ServiceToken string `json:"service_token"`
-	Id           string `json:"id"`
+// deleteBatch 在主库上复查实例仍然不健康，记录审计日志后删除一批实例，返回这一批需要删除的实例数
+func (job *DeleteUnHealthyInstanceJob) deleteBatch(ids []string, limitTime int, cfg common.AppConfig,
+	report *common.RunReport) (int, error) {
+	kept, err := store.GetStore().RecheckUnhealthyInstances(ids, limitTime)
+	if err != nil {
+		return len(ids), err
+	}
+	if ids = report.SkipChanged(common.KindInstance, ids, kept); len(ids) == 0 {
+		return 0, nil
+	}
+	requestID := audit.NewRequestID(cfg)
+	if err := audit.BeforeDelete(job.Name(), requestID, report.CandidatesOf(common.KindInstance, ids)); err != nil {
+		return len(ids), err
+	}
+	if err := job.client.DeleteInstances(requestID, "异常实例定时自动删除", ids); err != nil {
+		return len(ids), fmt.Errorf("fail to delete the instance, id:%s, %v", ids, err)
+	}
+	glog.Infof("success to delete the instance, id:%s", ids)
+	return len(ids), nil
 }
 
-// getParams 设置HTTP请求的请求参数
-func getParams(ids []string, cfg common.AppConfig) ([]byte, error) {
-	if len(ids) == 0 {
-		return nil, fmt.Errorf("delete: the id is NUll")
+func (job *DeleteUnHealthyInstanceJob) deleteUnHealthInstance(cfg common.AppConfig) (*common.RunReport, error) {
+	glog.Info("begin delete unhealthy instance task")
+	limitTime, err := protect.QueryLimitTime(cfg.Cleanup.LimitedTime)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := cfg.Selector.Compile()
+	if err != nil {
+		return nil, err
 	}
 
-	var responses []ResponseParam
-	for _, id := range ids {
-		resp := ResponseParam{
-			Id: id,
+	report := common.NewRunReport(job.Name(), cfg.DryRun)
+	checker := guard.NewChecker(cfg)
+	pager := func(lastID string, limit int) ([]*store.Instance, error) {
+		return store.GetStore().LoadUnhealthyInstancesAfter(lastID, limitTime, limit)
+	}
+	// 按主键分页查询，每页删除后再查询下一页，避免一次加载大量实例以及长时间的查询；
+	// 在删除前的扫描之前创建，两次扫描共用 maxRunTime
+	it := store.NewInstanceIterator(pager, cfg.Cleanup)
+	if !cfg.DryRun && checker.Enabled() {
+		if err := precheck(checker, pager, matcher, cfg, report); err != nil {
+			if err == guard.ErrHeld {
+				return report, nil
+			}
+			return report, err
 		}
-		responses = append(responses, resp)
+	}
 
+	minInstances := newMinInstancesFilter(cfg)
+	t := throttle.New(job.Name(), cfg.Cleanup)
+	for {
+		instances, err := it.Next()
+		if err != nil {
+			return report, err
+		}
+		if len(instances) == 0 {
+			break
+		}
+
+		instances, err = selectInstances(it, instances, matcher, cfg, minInstances, report)
+		if err != nil {
+			return report, err
+		}
+		deleteInstances := make([]string, 0, len(instances))
+		for _, ins := range instances {
+			report.AddCandidate(newCandidate(ins, fmt.Sprintf("unhealthy for more than %d minutes",
+				cfg.Cleanup.LimitedTime)))
+			deleteInstances = append(deleteInstances, ins.ID)
+		}
+		if cfg.DryRun || len(deleteInstances) == 0 {
+			continue
+		}
+		// 删除前已经按全部待删除的实例检查过，这里按本次执行累计的数量再检查一次，防止扫描后数据发生了变化
+		if err := checker.Check(report); err != nil {
+			if err == guard.ErrHeld {
+				return report, nil
+			}
+			return report, err
+		}
+		if err := job.deleteInstances(t, deleteInstances, limitTime, cfg, report); err != nil {
+			return report, err
+		}
 	}
 
-	params, err := json.Marshal(responses)
-	if err != nil {
-		return nil, fmt.Errorf("marshall failed:%s", err)
+	if len(report.Candidates) == 0 {
+		glog.Info("there is no unhealthy instance to delete")
+		return report, nil
 	}
-	return params, nil
+	glog.Infof("scanned count %d, successful delete count %d", it.Scanned(), report.Deleted)
+	glog.Info("delete unhealthy instance task successful end")
+	return report, nil
 }
 
-func (job *DeleteUnHealthyInstanceJob) sendHttpRequest(deleteInstances []string, cfg common.AppConfig) error {
-
-	endpoint := cfg.Server.Endpoints[rand.Intn(len(cfg.Server.Endpoints))]
-
-	deleteAddress := fmt.Sprintf("http://%s/naming/v1/instances/delete", endpoint)
-	//发送POST请求，删除过期的实例
-	deleteInstancesBody, err := getParams(deleteInstances, cfg)
+// selectInstances 依次按选择器、保护标签和最少实例数过滤 it 加载的一页实例，被跳过的实例记录到 report 中，
+// 未超过 ttl 的实例不计入 deleteLimitedNum
+func selectInstances(it *store.InstanceIterator, instances []*store.Instance, matcher *common.Matcher,
+	cfg common.AppConfig, minInstances *minInstancesFilter, report *common.RunReport) ([]*store.Instance, error) {
+	instances = store.SelectInstances(matcher, instances)
+	instances, unexpired, err := protect.FilterInstances(instances, cfg.Cleanup.LimitedTime, report)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	client := &http.Client{}
-	request, err := http.NewRequest("POST", deleteAddress, bytes.NewBuffer(deleteInstancesBody))
-	if err != nil {
-		return fmt.Errorf("fail to create request, err %v", err)
+	it.Release(unexpired)
+	return minInstances.filter(instances, report)
+}
+
+// precheck 删除第一页之前按相同的条件扫描全部待删除的实例，按总数检查批量删除保护，
+// 避免网络分区等故障时前面几页已经删除后才触发保护。扫描同样受 maxRunTime 限制，
+// 超时后无法得到完整的待删除数量，按 hold 处理，本次执行不做任何删除
+func precheck(checker *guard.Checker, pager store.InstancePager, matcher *common.Matcher, cfg common.AppConfig,
+	report *common.RunReport) error {
+	it := store.NewInstanceIterator(pager, cfg.Cleanup)
+	// 扫描时跳过的实例在删除时会重新记录，这里记录到临时的 report 中
+	scratch := common.NewRunReport(report.Job, true)
+	minInstances := newMinInstancesFilter(cfg)
+	var candidates []common.Candidate
+	for {
+		instances, err := it.Next()
+		if err != nil {
+			return err
+		}
+		if len(instances) == 0 {
+			break
+		}
+		instances, err = selectInstances(it, instances, matcher, cfg, minInstances, scratch)
+		if err != nil {
+			return err
+		}
+		for _, ins := range instances {
+			candidates = append(candidates, newCandidate(ins, ""))
+		}
 	}
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Request-Id", cfg.Server.RequestPrefix+uuid.New().String())
-	request.Header.Set("Staffname", "异常实例定时自动删除")
-	request.Header.Set("X-Polaris-Token", job.cfg.Server.AuthToken)
-	resp, err := client.Do(request)
-	if err != nil {
-		return fmt.Errorf("fail to get response, err %v", err)
+	if it.TimedOut() {
+		return checker.Hold(report, fmt.Sprintf("maxRunTime %ds is exhausted after scanning %d instances "+
+			"before the mass deletion guard check", cfg.Cleanup.MaxRunTime, it.Scanned()), len(candidates))
 	}
-	defer resp.Body.Close()
+	glog.Infof("[%s] %d of %d scanned instances to delete before the mass deletion guard check",
+		report.Job, len(candidates), it.Scanned())
+	return checker.CheckCandidates(report, candidates)
+}
 
-	//若成功，则不返回body
-	if resp.StatusCode == 200 {
-		glog.Infof("success to delete the instance, id:%s", deleteInstances)
+// deleteInstances 按照 batchDeleteNum 分批并发删除一页实例
+func (job *DeleteUnHealthyInstanceJob) deleteInstances(t *throttle.Throttle, deleteInstances []string, limitTime int,
+	cfg common.AppConfig, report *common.RunReport) error {
+	return t.Run(deleteInstances, func(batch []string) error {
+		num, err := job.deleteBatch(batch, limitTime, cfg, report)
+		if err != nil {
+			report.AddFailed(common.KindInstance, num)
+			return fmt.Errorf("id:%s, fail to delete, err is %v", batch, err)
+		}
+		report.AddDeleted(common.KindInstance, num)
 		return nil
-	}
+	})
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("fail to read response, err %v", err)
-	}
-	var jsonRsp Response
-	if err = json.Unmarshal(body, &jsonRsp); err != nil {
-		return err
-	}
-	return fmt.Errorf("fail to delete the instance, id:%s, code:%d, info:%s",
-		deleteInstances, jsonRsp.Code, jsonRsp.Info)
+// minInstancesFilter 过滤掉删除后会让服务低于最少实例数的实例。各服务的实例数只在一次任务执行中统计一次，
+// 之后按本次执行已经选中删除的实例数扣减，复查时被跳过的实例仍按删除计算，结果偏保守
+type minInstancesFilter struct {
+	cfg    common.AppConfig
+	counts map[store.ServiceKey]store.InstanceCount
+	// deleting 本次执行各服务已经选中删除的实例数
+	deleting map[store.ServiceKey]int
 }
 
-func (job *DeleteUnHealthyInstanceJob) deleteUnHealthInstance(cfg common.AppConfig) error {
-	glog.Info("begin delete unhealthy instance task")
-	selectReportSQL := "SELECT id FROM instance where flag=0 and enable_health_check=1 " +
-		"and health_status=0 and mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) limit ?"
-	var deleteInstances []string
-	var err error
+func newMinInstancesFilter(cfg common.AppConfig) *minInstancesFilter {
+	return &minInstancesFilter{cfg: cfg, deleting: map[store.ServiceKey]int{}}
+}
 
-	rows, err := store.GetStore().GetDB().Query(selectReportSQL, cfg.Cleanup.LimitedTime, cfg.Cleanup.LimitedNum)
-	if err != nil {
-		return err
+// filter 返回可以删除的实例，被过滤的实例记录到 report 中
+func (f *minInstancesFilter) filter(instances []*store.Instance, report *common.RunReport) ([]*store.Instance, error) {
+	if !f.cfg.MinInstances.Enabled() || len(instances) == 0 {
+		return instances, nil
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var expiredId string
-		err = rows.Scan(&expiredId)
+	if f.counts == nil {
+		counts, err := store.GetStore().CountServiceInstances()
 		if err != nil {
-			return fmt.Errorf("fail to read data from t_instance_unhealth, err is %v", err)
+			return nil, err
 		}
-		deleteInstances = append(deleteInstances, expiredId)
-	}
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("fetch rows next err:%s", err)
-	}
-
-	counter := 0
-	batchDeleteNum := cfg.Cleanup.BatchDeleteNum
-	if batchDeleteNum == 0 {
-		batchDeleteNum = 100
+		f.counts = counts
 	}
 
-	var deleteIns []string
+	out := make([]*store.Instance, 0, len(instances))
+	for _, ins := range instances {
+		// 服务已经不存在的实例无需保护
+		if ins.Service == "" {
+			out = append(out, ins)
+			continue
+		}
+		key := store.ServiceKey{Namespace: ins.Namespace, Service: ins.Service}
+		count := f.counts[key]
+		minTotal, minHealthy := f.cfg.MinInstances.For(ins.Namespace, ins.Service)
+		left := count.Total - f.deleting[key] - 1
 
-	for _, v := range deleteInstances {
-		deleteIns = append(deleteIns, v)
-		counter++
-		if counter >= batchDeleteNum {
-			err = job.sendHttpRequest(deleteIns, cfg)
-			if err != nil {
-				return fmt.Errorf("id:%s, fail to delete, err is %v", deleteIns, err)
-			}
-			counter = 0
-			deleteIns = nil
-			time.Sleep(time.Second)
+		var reason string
+		switch {
+		case count.Healthy < minHealthy:
+			reason = fmt.Sprintf("service has %d healthy instances, fewer than minimum %d", count.Healthy, minHealthy)
+		case left < minTotal:
+			reason = fmt.Sprintf("service would be left with %d instances, fewer than minimum %d", left, minTotal)
 		}
-	}
-	if counter > 0 {
-		err = job.sendHttpRequest(deleteIns, cfg)
-		if err != nil {
-			return fmt.Errorf("id:%s, fail to delete, err is %v", deleteIns, err)
+		if reason != "" {
+			report.AddSkipped(newCandidate(ins, reason))
+			continue
 		}
+		f.deleting[key]++
+		out = append(out, ins)
 	}
-	if len(deleteInstances) == 0 {
-		return fmt.Errorf("there is no instance to delete")
+	return out, nil
+}
+
+func newCandidate(ins *store.Instance, reason string) common.Candidate {
+	return common.Candidate{
+		Kind:      common.KindInstance,
+		ID:        ins.ID,
+		Namespace: ins.Namespace,
+		Service:   ins.Service,
+		Host:      ins.Host,
+		Port:      ins.Port,
+		Reason:    reason,
 	}
-	glog.Infof("successful delete count %d, list: %v", len(deleteInstances), deleteInstances)
-	glog.Info("delete unhealthy instance task successful end")
-	return nil
 }
@@ -0,0 +1,261 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package cleanunhealthy
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/polaris"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+// countStore 只实现实例数统计，记录统计的次数
+type countStore struct {
+	store.Store
+	counts map[store.ServiceKey]store.InstanceCount
+	calls  int
+}
+
+func (c *countStore) CountServiceInstances() (map[store.ServiceKey]store.InstanceCount, error) {
+	c.calls++
+	return c.counts, nil
+}
+
+func ids(instances []*store.Instance) []string {
+	out := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		out = append(out, ins.ID)
+	}
+	return out
+}
+
+func TestMinInstancesFilterAcrossPages(t *testing.T) {
+	db := &countStore{counts: map[store.ServiceKey]store.InstanceCount{
+		{Namespace: "Test", Service: "foo"}: {Total: 5, Healthy: 2},
+		{Namespace: "Test", Service: "bar"}: {Total: 5, Healthy: 0},
+	}}
+	store.SetStore(db)
+	defer store.SetStore(nil)
+
+	cfg := common.AppConfig{MinInstances: common.MinInstances{Total: 2, Healthy: 1}}
+	f := newMinInstancesFilter(cfg)
+	report := common.NewRunReport("DeleteUnHealthyInstance", false)
+	ins := func(id, service string) *store.Instance {
+		return &store.Instance{ID: id, Namespace: "Test", Service: service}
+	}
+
+	// foo 有 5 个实例，至少保留 2 个，跨页累计最多删除 3 个；bar 没有健康实例，不删除
+	pages := []struct {
+		instances []*store.Instance
+		want      []string
+	}{
+		{instances: []*store.Instance{ins("f1", "foo"), ins("f2", "foo"), ins("b1", "bar")}, want: []string{"f1", "f2"}},
+		{instances: []*store.Instance{ins("f3", "foo"), ins("f4", "foo"), ins("o1", "")}, want: []string{"f3", "o1"}},
+	}
+	for i, page := range pages {
+		out, err := f.filter(page.instances, report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(out); !reflect.DeepEqual(got, page.want) {
+			t.Fatalf("page %d: kept %v, want %v", i, got, page.want)
+		}
+	}
+	if db.calls != 1 {
+		t.Fatalf("instances counted %d times, want once per run", db.calls)
+	}
+	var skipped []string
+	for _, c := range report.Skipped {
+		skipped = append(skipped, c.ID)
+	}
+	if want := []string{"b1", "f4"}; !reflect.DeepEqual(skipped, want) {
+		t.Fatalf("skipped %v, want %v", skipped, want)
+	}
+}
+
+// unhealthyStore 按主键分页返回不健康的实例，记录分页查询的次数
+type unhealthyStore struct {
+	countStore
+	instances []*store.Instance
+	loads     int
+	// delay 每次分页查询的耗时
+	delay time.Duration
+}
+
+func (u *unhealthyStore) LoadUnhealthyInstancesAfter(lastID string, _, limitNum int) ([]*store.Instance, error) {
+	u.loads++
+	time.Sleep(u.delay)
+	out := make([]*store.Instance, 0, limitNum)
+	for _, ins := range u.instances {
+		if ins.ID > lastID && len(out) < limitNum {
+			out = append(out, ins)
+		}
+	}
+	return out, nil
+}
+
+func (u *unhealthyStore) RecheckUnhealthyInstances(ids []string, _ int) ([]string, error) {
+	return ids, nil
+}
+
+func (u *unhealthyStore) LoadLabelValues(string) ([]string, error) {
+	return nil, nil
+}
+
+func (u *unhealthyStore) LoadInstanceLabels([]string, []string) (map[string]map[string]string, error) {
+	return map[string]map[string]string{}, nil
+}
+
+// deleteClient 记录删除的实例
+type deleteClient struct {
+	polaris.API
+	lock    sync.Mutex
+	deleted []string
+}
+
+func (d *deleteClient) DeleteInstances(_, _ string, ids []string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.deleted = append(d.deleted, ids...)
+	return nil
+}
+
+func TestGuardBeforeFirstDeletion(t *testing.T) {
+	unhealthy := func(n int) []*store.Instance {
+		out := make([]*store.Instance, 0, n)
+		for i := 0; i < n; i++ {
+			out = append(out, &store.Instance{ID: string(rune('a' + i)), Namespace: "Test", Service: "foo",
+				Age: 2 * time.Hour})
+		}
+		return out
+	}
+	// 服务有 10 个实例，每页 2 个，删除比例超过 30% 时触发保护
+	tests := []struct {
+		name        string
+		instances   int
+		guard       common.Guard
+		wantDeleted int
+		wantHeld    bool
+		wantErr     bool
+		wantLoads   int
+	}{
+		{name: "hold before the first page", instances: 4, guard: common.Guard{MaxDeletePercent: 30, Action: "hold"},
+			wantHeld: true, wantLoads: 3},
+		{name: "abort before the first page", instances: 4, guard: common.Guard{MaxDeletePercent: 30},
+			wantErr: true, wantLoads: 3},
+		{name: "below the threshold", instances: 3, guard: common.Guard{MaxDeletePercent: 30},
+			wantDeleted: 3, wantLoads: 4},
+		{name: "guard disabled", instances: 4, wantDeleted: 4, wantLoads: 3},
+		{name: "force", instances: 4, guard: common.Guard{MaxDeletePercent: 30, Force: true},
+			wantDeleted: 4, wantLoads: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &unhealthyStore{instances: unhealthy(tt.instances)}
+			db.counts = map[store.ServiceKey]store.InstanceCount{
+				{Namespace: "Test", Service: "foo"}: {Total: 10, Healthy: 10 - tt.instances},
+			}
+			store.SetStore(db)
+			defer store.SetStore(nil)
+
+			client := &deleteClient{}
+			job := &DeleteUnHealthyInstanceJob{client: client}
+			cfg := common.AppConfig{
+				Cleanup: common.Cleanup{LimitedTime: 60, LimitedNum: 100, PageSize: 2, DeleteRate: 1000},
+				Guard:   tt.guard,
+			}
+			report, err := job.deleteUnHealthInstance(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if len(client.deleted) != tt.wantDeleted {
+				t.Errorf("deleted %v, want %d instances", client.deleted, tt.wantDeleted)
+			}
+			if held := report.Held != ""; held != tt.wantHeld {
+				t.Errorf("held = %q, want held %v", report.Held, tt.wantHeld)
+			}
+			if db.loads != tt.wantLoads {
+				t.Errorf("loaded %d pages, want %d", db.loads, tt.wantLoads)
+			}
+		})
+	}
+}
+
+func TestUnexpiredInstancesNotCounted(t *testing.T) {
+	// 前两个实例未超过保留时间，不占用 deleteLimitedNum，后面的两个实例仍然会被删除
+	db := &unhealthyStore{instances: []*store.Instance{
+		{ID: "a", Namespace: "Test", Service: "foo", Age: 10 * time.Minute},
+		{ID: "b", Namespace: "Test", Service: "foo", Age: 10 * time.Minute},
+		{ID: "c", Namespace: "Test", Service: "foo", Age: 2 * time.Hour},
+		{ID: "d", Namespace: "Test", Service: "foo", Age: 2 * time.Hour},
+		{ID: "e", Namespace: "Test", Service: "foo", Age: 2 * time.Hour},
+	}}
+	store.SetStore(db)
+	defer store.SetStore(nil)
+
+	client := &deleteClient{}
+	job := &DeleteUnHealthyInstanceJob{client: client}
+	cfg := common.AppConfig{Cleanup: common.Cleanup{LimitedTime: 60, LimitedNum: 2, PageSize: 2, DeleteRate: 1000}}
+	report, err := job.deleteUnHealthInstance(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c", "d"}; !reflect.DeepEqual(client.deleted, want) {
+		t.Errorf("deleted %v, want %v", client.deleted, want)
+	}
+	var skipped []string
+	for _, c := range report.Skipped {
+		skipped = append(skipped, c.ID)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped %v, want %v", skipped, want)
+	}
+}
+
+func TestPrecheckHoldsWhenMaxRunTimeExhausted(t *testing.T) {
+	instances := make([]*store.Instance, 0, 6)
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		instances = append(instances, &store.Instance{ID: id, Namespace: "Test", Service: "foo", Age: 2 * time.Hour})
+	}
+	// 每页查询 600ms，1 秒内扫描不完 3 页，删除前的扫描超时后不做任何删除
+	db := &unhealthyStore{instances: instances, delay: 600 * time.Millisecond}
+	db.counts = map[store.ServiceKey]store.InstanceCount{{Namespace: "Test", Service: "foo"}: {Total: 100}}
+	store.SetStore(db)
+	defer store.SetStore(nil)
+
+	client := &deleteClient{}
+	job := &DeleteUnHealthyInstanceJob{client: client}
+	cfg := common.AppConfig{
+		Cleanup: common.Cleanup{LimitedTime: 60, LimitedNum: 100, PageSize: 2, MaxRunTime: 1, DeleteRate: 1000},
+		Guard:   common.Guard{MaxDeletePercent: 50},
+	}
+	report, err := job.deleteUnHealthInstance(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.deleted) != 0 {
+		t.Errorf("deleted %v, want nothing", client.deleted)
+	}
+	if report.Held == "" {
+		t.Error("run is not held after the scan timed out")
+	}
+}
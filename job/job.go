@@ -18,9 +18,12 @@
 package job
 
 import (
+	"reflect"
+
 	"github.com/polarismesh/polaris-cleanup/common"
 	"github.com/polarismesh/polaris-cleanup/job/cleandeleted"
 	"github.com/polarismesh/polaris-cleanup/job/cleanempty"
+	"github.com/polarismesh/polaris-cleanup/job/cleank8s"
 	"github.com/polarismesh/polaris-cleanup/job/cleanunhealthy"
 )
 
@@ -30,8 +33,11 @@ var (
 
 func init() {
 	RegisterJob(&cleandeleted.DeleteSoftDeleteInstanceJob{})
+	RegisterJob(&cleandeleted.DeleteSoftDeleteServiceJob{})
+	RegisterJob(&cleandeleted.DeleteSoftDeleteRulesJob{})
 	RegisterJob(&cleanunhealthy.DeleteUnHealthyInstanceJob{})
 	RegisterJob(&cleanempty.DeleteEmptyServiceJob{})
+	RegisterJob(&cleank8s.DeleteK8sInvalidInstanceJob{})
 }
 
 func RegisterJob(j PolarisCleanJob) {
@@ -48,10 +54,21 @@ func GetAllRegister() map[string]PolarisCleanJob {
 	return ret
 }
 
+// NewJob 创建一个新的任务实例，重新加载配置时使用，避免修改正在执行的任务
+func NewJob(name string) (PolarisCleanJob, bool) {
+	j, ok := slots[name]
+	if !ok {
+		return nil, false
+	}
+	return reflect.New(reflect.TypeOf(j).Elem()).Interface().(PolarisCleanJob), true
+}
+
 type PolarisCleanJob interface {
 	Init(cfg common.AppConfig)
 	CronSpec() string
 	Execute() func()
+	// Run 立即执行一次任务，并返回执行结果
+	Run() (*common.RunReport, error)
 	Name() string
 	Destory() error
 }
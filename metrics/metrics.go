@@ -0,0 +1,205 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "polaris_cleanup"
+
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	jobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Number of job runs by result.",
+	}, []string{"job", "result"})
+
+	jobLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful job run.",
+	}, []string{"job"})
+
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Duration of job runs.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600},
+	}, []string{"job"})
+
+	candidates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "candidates_total",
+		Help:      "Number of resources found to clean by resource type.",
+	}, []string{"job", "kind"})
+
+	deletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deletions_total",
+		Help:      "Number of deleted resources by resource type and result.",
+	}, []string{"job", "kind", "result"})
+
+	polarisAPIDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "polaris_api_duration_seconds",
+		Help:      "Latency of the polaris server api calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api"})
+
+	polarisAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "polaris_api_errors_total",
+		Help:      "Number of failed polaris server api calls.",
+	}, []string{"api"})
+
+	mysqlDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mysql_duration_seconds",
+		Help:      "Latency of the polaris database operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	mysqlErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mysql_errors_total",
+		Help:      "Number of failed polaris database operations.",
+	}, []string{"operation"})
+
+	boltDBDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "boltdb_duration_seconds",
+		Help:      "Latency of the polaris standalone data file operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	boltDBErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "boltdb_errors_total",
+		Help:      "Number of failed polaris standalone data file operations.",
+	}, []string{"operation"})
+
+	guardTrips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "guard_trips_total",
+		Help:      "Number of job runs stopped by the mass deletion guard by action.",
+	}, []string{"job", "action"})
+
+	deleteRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "delete_rate",
+		Help:      "Current limit of deleted resources per second after adaptive throttling.",
+	}, []string{"job"})
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		jobRuns, jobLastSuccess, jobDuration, candidates, deletions,
+		polarisAPIDuration, polarisAPIErrors, mysqlDuration, mysqlErrors,
+		boltDBDuration, boltDBErrors, guardTrips, deleteRate,
+	)
+}
+
+// Handler 以 Prometheus 格式输出监控指标的 HTTP handler
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveJobDuration 记录任务执行的耗时
+func ObserveJobDuration(job string, cost time.Duration) {
+	jobDuration.WithLabelValues(job).Observe(cost.Seconds())
+}
+
+// ObserveJobResult 记录任务执行的结果
+func ObserveJobResult(job string, err error) {
+	if err != nil {
+		jobRuns.WithLabelValues(job, resultFailure).Inc()
+		return
+	}
+	jobRuns.WithLabelValues(job, resultSuccess).Inc()
+	jobLastSuccess.WithLabelValues(job).SetToCurrentTime()
+}
+
+// AddCandidates 记录发现的待清理资源数量
+func AddCandidates(job, kind string, num int) {
+	candidates.WithLabelValues(job, kind).Add(float64(num))
+}
+
+// AddDeletions 记录删除成功或者失败的资源数量
+func AddDeletions(job, kind string, num int, success bool) {
+	result := resultSuccess
+	if !success {
+		result = resultFailure
+	}
+	deletions.WithLabelValues(job, kind, result).Add(float64(num))
+}
+
+// ObservePolarisAPI 记录一次北极星接口调用的耗时和结果，请求失败或者 HTTP 状态码不是 200 时记为错误
+func ObservePolarisAPI(api string, start time.Time, resp *http.Response, err error) {
+	polarisAPIDuration.WithLabelValues(api).Observe(time.Since(start).Seconds())
+	if err != nil || resp.StatusCode != http.StatusOK {
+		polarisAPIErrors.WithLabelValues(api).Inc()
+	}
+}
+
+// ObservePolarisGRPC 记录一次北极星 gRPC 接口调用的耗时和结果
+func ObservePolarisGRPC(method string, start time.Time, err error) {
+	polarisAPIDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		polarisAPIErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// ObserveMysql 记录一次数据库操作的耗时和结果
+func ObserveMysql(operation string, start time.Time, err error) {
+	mysqlDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		mysqlErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// ObserveBoltDB 记录一次北极星单机版数据文件操作的耗时和结果
+func ObserveBoltDB(operation string, start time.Time, err error) {
+	boltDBDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		boltDBErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// AddGuardTrip 记录一次批量删除保护的触发
+func AddGuardTrip(job, action string) {
+	guardTrips.WithLabelValues(job, action).Inc()
+}
+
+// SetDeleteRate 记录任务当前每秒删除资源数的上限
+func SetDeleteRate(job string, rate float64) {
+	deleteRate.WithLabelValues(job).Set(rate)
+}
@@ -0,0 +1,212 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package polaris
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+)
+
+// listPageSize 分页查询服务和实例的每页数量
+const listPageSize = 100
+
+// API 任务需要的北极星服务端操作，HTTP 和 gRPC 接入点使用相同的接口，服务端返回错误时返回 *Error
+type API interface {
+	// ListServices 查询元数据包含 metadata 中所有键值对的服务
+	ListServices(metadata map[string]string) ([]Service, error)
+	// GetInstances 查询服务下的所有实例
+	GetInstances(namespace, service string) ([]Instance, error)
+	// DeleteInstances 通过实例 id 批量反注册实例
+	DeleteInstances(requestID, staffname string, ids []string) error
+	// DeleteServices 批量删除服务，返回每个服务的删除结果
+	DeleteServices(requestID, staffname string, services []ServiceKey) ([]ServiceResult, error)
+	// Close 关闭到接入点的连接
+	Close() error
+}
+
+// NewAPI 根据 server.protocol 创建 HTTP 或者 gRPC 的客户端
+func NewAPI(server common.Server) API {
+	if server.Protocol == common.ProtocolGRPC {
+		return NewGRPCClient(server)
+	}
+	return NewClient(server)
+}
+
+// Service 北极星中的服务，实例数量只有 HTTP 接口会返回，gRPC 客户端通过查询实例补全
+type Service struct {
+	Name                 string            `json:"name"`
+	Namespace            string            `json:"namespace"`
+	Metadata             map[string]string `json:"metadata"`
+	Mtime                string            `json:"mtime"`
+	TotalInstanceCount   int32             `json:"total_instance_count"`
+	HealthyInstanceCount int32             `json:"healthy_instance_count"`
+}
+
+// Instance 北极星中的服务实例
+type Instance struct {
+	Id        string            `json:"id"`
+	Service   string            `json:"service"`
+	Namespace string            `json:"namespace"`
+	Host      string            `json:"host"`
+	Port      int               `json:"port"`
+	Healthy   bool              `json:"healthy"`
+	Isolate   bool              `json:"isolate"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+// ServiceKey 删除服务接口的请求参数
+type ServiceKey struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// ServiceResult 删除单个服务的结果
+type ServiceResult struct {
+	Code    int        `json:"code"`
+	Info    string     `json:"info"`
+	Service ServiceKey `json:"service"`
+}
+
+// matchMetadata 服务的元数据是否包含 filter 中所有的键值对
+func matchMetadata(metadata, filter map[string]string) bool {
+	for k, v := range filter {
+		if metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ListServices HTTP 接口只支持按一个元数据过滤，其余的键值对在本地过滤
+func (c *Client) ListServices(metadata map[string]string) ([]Service, error) {
+	query := url.Values{"limit": []string{strconv.Itoa(listPageSize)}}
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		query.Set("keys", keys[0])
+		query.Set("values", metadata[keys[0]])
+	}
+
+	var services []Service
+	for offset := 0; ; {
+		query.Set("offset", strconv.Itoa(offset))
+		var response struct {
+			Amount   int       `json:"amount"`
+			Size     int       `json:"size"`
+			Services []Service `json:"services"`
+		}
+		err := c.Do(Request{
+			Method:     http.MethodGet,
+			Path:       "/naming/v1/services",
+			Query:      query,
+			Idempotent: true,
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range response.Services {
+			if matchMetadata(svc.Metadata, metadata) {
+				services = append(services, svc)
+			}
+		}
+		offset += response.Size
+		if response.Size == 0 || offset >= response.Amount {
+			return services, nil
+		}
+	}
+}
+
+// GetInstances 分页查询服务下的所有实例
+func (c *Client) GetInstances(namespace, service string) ([]Instance, error) {
+	query := url.Values{
+		"namespace": []string{namespace},
+		"service":   []string{service},
+		"limit":     []string{strconv.Itoa(listPageSize)},
+	}
+	var instances []Instance
+	for offset := 0; ; {
+		query.Set("offset", strconv.Itoa(offset))
+		var response struct {
+			Amount    int        `json:"amount"`
+			Size      int        `json:"size"`
+			Instances []Instance `json:"instances"`
+		}
+		err := c.Do(Request{
+			Method:     http.MethodGet,
+			Path:       "/naming/v1/instances",
+			Query:      query,
+			Idempotent: true,
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, response.Instances...)
+		offset += response.Size
+		if response.Size == 0 || offset >= response.Amount {
+			return instances, nil
+		}
+	}
+}
+
+// deleteInstanceParam 删除服务实例接口的请求参数
+type deleteInstanceParam struct {
+	Id string `json:"id"`
+}
+
+// DeleteInstances 实例已经不存在时重复删除不会产生副作用，因此失败后会重试
+func (c *Client) DeleteInstances(requestID, staffname string, ids []string) error {
+	params := make([]deleteInstanceParam, 0, len(ids))
+	for _, id := range ids {
+		params = append(params, deleteInstanceParam{Id: id})
+	}
+	return c.Do(Request{
+		Method:     http.MethodPost,
+		Path:       "/naming/v1/instances/delete",
+		Body:       params,
+		RequestID:  requestID,
+		Staffname:  staffname,
+		Idempotent: true,
+	}, nil)
+}
+
+// DeleteServices 北极星不会删除还有实例的服务，重复删除不会产生副作用，因此失败后会重试。
+// 部分服务删除失败时接口整体返回错误，此时只返回每个服务的结果
+func (c *Client) DeleteServices(requestID, staffname string, services []ServiceKey) ([]ServiceResult, error) {
+	var response struct {
+		Responses []ServiceResult `json:"responses"`
+	}
+	err := c.Do(Request{
+		Method:     http.MethodPost,
+		Path:       "/naming/v1/services/delete",
+		Body:       services,
+		RequestID:  requestID,
+		Staffname:  staffname,
+		Idempotent: true,
+	}, &response)
+	if err != nil && len(response.Responses) == 0 {
+		return nil, err
+	}
+	return response.Responses, nil
+}
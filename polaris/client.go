@@ -0,0 +1,219 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package polaris
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/metrics"
+)
+
+const (
+	// CodeSuccess 北极星接口执行成功的返回码
+	CodeSuccess = 200000
+
+	defaultTimeout       = 10 * time.Second
+	defaultMaxRetries    = 2
+	defaultRetryBackoff  = 200 * time.Millisecond
+	maxRetryBackoff      = 5 * time.Second
+	defaultEjectFailures = 3
+	defaultEjectTime     = 30 * time.Second
+	maxIdleConnsPerHost  = 16
+)
+
+// Request 一次北极星接口的调用
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	// Body 请求体，会被编码为 JSON
+	Body interface{}
+	// RequestID 为空时使用 requestPrefix 加上随机 uuid
+	RequestID string
+	Staffname string
+	// Idempotent 重复执行不会产生副作用的请求，失败后可以换一个接入点重试
+	Idempotent bool
+	// Endpoint 指定接入点，为空时从配置的接入点中选择，指定时不会重试其他接入点
+	Endpoint string
+}
+
+// Error 北极星接口返回的错误，Status 为 HTTP 状态码，gRPC 接口时为 0，Code 为回复中的返回码
+type Error struct {
+	Status int
+	Code   int
+	Info   string
+}
+
+func (e *Error) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("polaris error, code: %d, info: %s", e.Code, e.Info)
+	}
+	return fmt.Sprintf("polaris error, status: %d, code: %d, info: %s", e.Status, e.Code, e.Info)
+}
+
+// CodeOf 返回错误中北极星的返回码，不是北极星接口返回的错误时返回 0
+func CodeOf(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return 0
+}
+
+// Client 访问北极星 HTTP 接口的客户端，复用连接，请求失败的接入点会被暂时摘除
+type Client struct {
+	server     common.Server
+	httpClient *http.Client
+	policy     retryPolicy
+	// err 创建客户端时的错误，如证书无法加载，每次请求时返回
+	err error
+}
+
+// NewClient 根据 server 配置创建客户端，未配置的参数使用默认值，TLS 配置无效时所有请求都会返回错误
+func NewClient(server common.Server) *Client {
+	c := &Client{
+		server: server,
+		policy: newRetryPolicy(server.Endpoints, server),
+	}
+	timeout := serverTimeout(server)
+	tlsConfig, err := server.TLSConfig()
+	c.err = err
+	c.httpClient = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          maxIdleConnsPerHost * len(server.Endpoints),
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   timeout,
+		},
+	}
+	return c
+}
+
+// Close 关闭空闲的连接
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// serverTimeout 单次请求的超时时间
+func serverTimeout(server common.Server) time.Duration {
+	if server.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(server.Timeout) * time.Second
+}
+
+// Do 调用北极星接口，out 不为空时将回复解码到 out 中。HTTP 状态码不为 2xx 或者返回码不为 200000 时返回 *Error，
+// 此时 out 中仍然是解码后的回复，可以用于读取批量接口中每个子请求的结果
+func (c *Client) Do(req Request, out interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	var body []byte
+	if req.Body != nil {
+		data, err := json.Marshal(req.Body)
+		if err != nil {
+			return fmt.Errorf("marshall failed:%s", err)
+		}
+		body = data
+	}
+	if req.RequestID == "" {
+		req.RequestID = c.server.RequestPrefix + uuid.New().String()
+	}
+	return c.policy.call(req.Method+" "+req.Path, req.Endpoint, req.Idempotent, func(endpoint string) (bool, error) {
+		return c.send(endpoint, req, body, out)
+	})
+}
+
+// send 向一个接入点发送请求，返回的 retryable 表示失败是否由接入点引起，可以换一个接入点重试
+func (c *Client) send(endpoint string, req Request, body []byte, out interface{}) (bool, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(req.Method, fmt.Sprintf("%s://%s%s", c.server.URLScheme(), endpoint, req.Path), reader)
+	if err != nil {
+		return false, fmt.Errorf("fail to create request, err %v", err)
+	}
+	if len(req.Query) > 0 {
+		request.URL.RawQuery = req.Query.Encode()
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Request-Id", req.RequestID)
+	if req.Staffname != "" {
+		request.Header.Set("Staffname", req.Staffname)
+	}
+	request.Header.Set("X-Polaris-Token", c.server.AuthToken)
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(request)
+	metrics.ObservePolarisAPI(req.Path, start, resp, err)
+	if err != nil {
+		return true, fmt.Errorf("fail to get response, err %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, fmt.Errorf("fail to read response, err %v", err)
+	}
+	return decode(resp.StatusCode, data, out)
+}
+
+// decode 统一解析北极星接口的回复
+func decode(status int, data []byte, out interface{}) (bool, error) {
+	retryable := status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+	var result struct {
+		Code int    `json:"code"`
+		Info string `json:"info"`
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &result); err != nil {
+			return retryable, &Error{Status: status, Info: fmt.Sprintf("invalid response %q", string(data))}
+		}
+		if out != nil {
+			if err := json.Unmarshal(data, out); err != nil {
+				return retryable, fmt.Errorf("fail to decode response, status %d, err %v", status, err)
+			}
+		}
+	}
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		return retryable, &Error{Status: status, Code: result.Code, Info: result.Info}
+	}
+	if result.Code != 0 && result.Code != CodeSuccess {
+		return false, &Error{Status: status, Code: result.Code, Info: result.Info}
+	}
+	return false, nil
+}
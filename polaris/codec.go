@@ -0,0 +1,285 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 北极星 gRPC 接口（apiservice.proto）中用到的消息字段编号，只编解码清理任务需要的字段，
+// 避免引入北极星生成的 pb 代码及其依赖
+const (
+	// DiscoverRequest
+	discoverRequestType    protowire.Number = 1
+	discoverRequestService protowire.Number = 2
+	// DiscoverRequest.DiscoverRequestType
+	discoverTypeInstance = 1
+	discoverTypeServices = 6
+
+	// Response 和 DiscoverResponse
+	responseCode              protowire.Number = 1
+	responseInfo              protowire.Number = 2
+	discoverResponseInstances protowire.Number = 5
+	discoverResponseServices  protowire.Number = 9
+	mapEntryKey               protowire.Number = 1
+	mapEntryValue             protowire.Number = 2
+	wrapperValue              protowire.Number = 1
+	serviceName               protowire.Number = 1
+	serviceNamespace          protowire.Number = 2
+	serviceMetadata           protowire.Number = 3
+	serviceMtime              protowire.Number = 14
+	instanceID                protowire.Number = 1
+	instanceService           protowire.Number = 2
+	instanceNamespace         protowire.Number = 3
+	instanceHost              protowire.Number = 4
+	instancePort              protowire.Number = 5
+	instanceHealthy           protowire.Number = 11
+	instanceIsolate           protowire.Number = 12
+	instanceMetadata          protowire.Number = 14
+)
+
+// rawMessage 已经编码好的 protobuf 消息
+type rawMessage struct {
+	data []byte
+}
+
+// rawCodec 直接收发 rawMessage，名称为 proto，与服务端的编码方式一致
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(*rawMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return m.data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*rawMessage)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	m.data = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// appendStringValue 编码 google.protobuf.StringValue 类型的字段，空字符串不编码
+func appendStringValue(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	var w []byte
+	w = protowire.AppendTag(w, wrapperValue, protowire.BytesType)
+	w = protowire.AppendString(w, v)
+	return appendMessage(b, num, w)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendStringMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	for k, v := range m {
+		var entry []byte
+		entry = protowire.AppendTag(entry, mapEntryKey, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, mapEntryValue, protowire.BytesType)
+		entry = protowire.AppendString(entry, v)
+		b = appendMessage(b, num, entry)
+	}
+	return b
+}
+
+// encodeService 编码 Service 消息
+func encodeService(namespace, name string, metadata map[string]string) []byte {
+	var b []byte
+	b = appendStringValue(b, serviceName, name)
+	b = appendStringValue(b, serviceNamespace, namespace)
+	return appendStringMap(b, serviceMetadata, metadata)
+}
+
+// encodeInstance 编码只包含 id 的 Instance 消息
+func encodeInstance(id string) []byte {
+	return appendStringValue(nil, instanceID, id)
+}
+
+// encodeDiscoverRequest 编码 DiscoverRequest 消息
+func encodeDiscoverRequest(typ uint64, service []byte) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, discoverRequestType, protowire.VarintType)
+	b = protowire.AppendVarint(b, typ)
+	return appendMessage(b, discoverRequestService, service)
+}
+
+// walkFields 依次处理消息中的字段，varint 类型的值通过 x 传入，bytes 类型的值通过 v 传入，其他类型的字段被跳过
+func walkFields(b []byte, fn func(num protowire.Number, v []byte, x uint64)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			x, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, nil, x)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, v, 0)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// wrapper google.protobuf 中 StringValue、UInt32Value、BoolValue 等包装类型的值
+type wrapper struct {
+	s string
+	x uint64
+}
+
+func decodeWrapper(b []byte) (wrapper, error) {
+	var w wrapper
+	err := walkFields(b, func(num protowire.Number, v []byte, x uint64) {
+		if num == wrapperValue {
+			w.s, w.x = string(v), x
+		}
+	})
+	return w, err
+}
+
+func decodeStringMap(entries [][]byte) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		var k, val string
+		err := walkFields(entry, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case mapEntryKey:
+				k = string(v)
+			case mapEntryValue:
+				val = string(v)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		m[k] = val
+	}
+	return m, nil
+}
+
+// message 解码后的消息，按字段编号保存包装类型的值以及嵌套消息的原始数据
+type message struct {
+	wrappers map[protowire.Number]wrapper
+	fields   map[protowire.Number][][]byte
+}
+
+// decodeMessage 解码消息，wrapperNums 中的字段按照包装类型解码
+func decodeMessage(b []byte, wrapperNums ...protowire.Number) (*message, error) {
+	m := &message{wrappers: map[protowire.Number]wrapper{}, fields: map[protowire.Number][][]byte{}}
+	isWrapper := map[protowire.Number]bool{}
+	for _, num := range wrapperNums {
+		isWrapper[num] = true
+	}
+	var err error
+	walkErr := walkFields(b, func(num protowire.Number, v []byte, _ uint64) {
+		if v == nil || err != nil {
+			return
+		}
+		if !isWrapper[num] {
+			m.fields[num] = append(m.fields[num], v)
+			return
+		}
+		m.wrappers[num], err = decodeWrapper(v)
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	return m, err
+}
+
+// decodeResponse 解码 Response 或者 DiscoverResponse 中的返回码
+func decodeResponse(b []byte) (*message, int, string, error) {
+	m, err := decodeMessage(b, responseCode, responseInfo)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return m, int(m.wrappers[responseCode].x), m.wrappers[responseInfo].s, nil
+}
+
+func decodeService(b []byte) (Service, error) {
+	m, err := decodeMessage(b, serviceName, serviceNamespace, serviceMtime)
+	if err != nil {
+		return Service{}, err
+	}
+	metadata, err := decodeStringMap(m.fields[serviceMetadata])
+	if err != nil {
+		return Service{}, err
+	}
+	return Service{
+		Name:      m.wrappers[serviceName].s,
+		Namespace: m.wrappers[serviceNamespace].s,
+		Metadata:  metadata,
+		Mtime:     m.wrappers[serviceMtime].s,
+	}, nil
+}
+
+func decodeInstance(b []byte) (Instance, error) {
+	m, err := decodeMessage(b, instanceID, instanceService, instanceNamespace, instanceHost, instancePort,
+		instanceHealthy, instanceIsolate)
+	if err != nil {
+		return Instance{}, err
+	}
+	metadata, err := decodeStringMap(m.fields[instanceMetadata])
+	if err != nil {
+		return Instance{}, err
+	}
+	return Instance{
+		Id:        m.wrappers[instanceID].s,
+		Service:   m.wrappers[instanceService].s,
+		Namespace: m.wrappers[instanceNamespace].s,
+		Host:      m.wrappers[instanceHost].s,
+		Port:      int(m.wrappers[instancePort].x),
+		Healthy:   m.wrappers[instanceHealthy].x != 0,
+		Isolate:   m.wrappers[instanceIsolate].x != 0,
+		Metadata:  metadata,
+	}, nil
+}
@@ -0,0 +1,159 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package polaris
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+)
+
+// endpointState 接入点的健康状态，所有客户端共享，一个任务摘除的接入点其他任务也不会再使用
+type endpointState struct {
+	failures     int
+	ejectedUntil time.Time
+}
+
+var (
+	endpointLock   sync.Mutex
+	endpointStates = map[string]*endpointState{}
+)
+
+// pickEndpoint 随机选择一个未被摘除且本次请求还没有尝试过的接入点，都不可用时退化为在所有接入点中随机选择
+func pickEndpoint(endpoints []string, tried map[string]bool) string {
+	endpointLock.Lock()
+	defer endpointLock.Unlock()
+
+	now := time.Now()
+	var healthy, untried []string
+	for _, endpoint := range endpoints {
+		if tried[endpoint] {
+			continue
+		}
+		untried = append(untried, endpoint)
+		if state, ok := endpointStates[endpoint]; ok && now.Before(state.ejectedUntil) {
+			continue
+		}
+		healthy = append(healthy, endpoint)
+	}
+	switch {
+	case len(healthy) > 0:
+		return healthy[rand.Intn(len(healthy))]
+	case len(untried) > 0:
+		return untried[rand.Intn(len(untried))]
+	default:
+		return endpoints[rand.Intn(len(endpoints))]
+	}
+}
+
+// markFailure 记录一次接入点失败，连续失败达到阈值后摘除一段时间
+func markFailure(endpoint string, ejectFailures int, ejectTime time.Duration) {
+	endpointLock.Lock()
+	defer endpointLock.Unlock()
+
+	state, ok := endpointStates[endpoint]
+	if !ok {
+		state = &endpointState{}
+		endpointStates[endpoint] = state
+	}
+	state.failures++
+	if state.failures >= ejectFailures {
+		state.failures = 0
+		state.ejectedUntil = time.Now().Add(ejectTime)
+	}
+}
+
+// markSuccess 接入点请求成功后清空失败计数并恢复
+func markSuccess(endpoint string) {
+	endpointLock.Lock()
+	defer endpointLock.Unlock()
+
+	delete(endpointStates, endpoint)
+}
+
+// retryPolicy 接入点的选择、摘除以及重试策略，HTTP 和 gRPC 客户端共用
+type retryPolicy struct {
+	endpoints     []string
+	maxRetries    int
+	retryBackoff  time.Duration
+	ejectFailures int
+	ejectTime     time.Duration
+}
+
+// newRetryPolicy 根据 server 配置创建重试策略，未配置的参数使用默认值
+func newRetryPolicy(endpoints []string, server common.Server) retryPolicy {
+	p := retryPolicy{
+		endpoints:     endpoints,
+		maxRetries:    server.MaxRetries,
+		retryBackoff:  time.Duration(server.RetryBackoff) * time.Millisecond,
+		ejectFailures: server.EjectFailures,
+		ejectTime:     time.Duration(server.EjectTime) * time.Second,
+	}
+	if p.maxRetries == 0 {
+		p.maxRetries = defaultMaxRetries
+	}
+	if p.retryBackoff <= 0 {
+		p.retryBackoff = defaultRetryBackoff
+	}
+	if p.ejectFailures <= 0 {
+		p.ejectFailures = defaultEjectFailures
+	}
+	if p.ejectTime <= 0 {
+		p.ejectTime = defaultEjectTime
+	}
+	return p
+}
+
+// call 选择接入点执行 fn，pinned 不为空时只使用该接入点。fn 返回的 retryable 表示失败由接入点引起，
+// 此时接入点记录一次失败，幂等请求会等待一段时间后换一个接入点重试
+func (p retryPolicy) call(name, pinned string, idempotent bool, fn func(endpoint string) (bool, error)) error {
+	if pinned == "" && len(p.endpoints) == 0 {
+		return fmt.Errorf("no polaris endpoint configured")
+	}
+	tried := map[string]bool{}
+	backoff := p.retryBackoff
+	for attempt := 0; ; attempt++ {
+		endpoint := pinned
+		if endpoint == "" {
+			endpoint = pickEndpoint(p.endpoints, tried)
+		}
+		tried[endpoint] = true
+
+		retryable, err := fn(endpoint)
+		if retryable && err != nil {
+			markFailure(endpoint, p.ejectFailures, p.ejectTime)
+		} else {
+			markSuccess(endpoint)
+		}
+		if err == nil {
+			return nil
+		}
+		if !retryable || !idempotent || pinned != "" || attempt >= p.maxRetries {
+			return err
+		}
+		glog.Warningf("[Polaris] %s on %s failed, retry after %v, %v", name, endpoint, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
@@ -0,0 +1,285 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package polaris
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	methodDiscover           = "/v1.PolarisGRPC/Discover"
+	methodDeregisterInstance = "/v1.PolarisGRPC/DeregisterInstance"
+)
+
+var discoverStreamDesc = &grpc.StreamDesc{
+	StreamName:    "Discover",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// GRPCClient 通过北极星的 gRPC 接口（v1.PolarisGRPC）访问服务端，与 HTTP 客户端使用相同的接入点摘除和重试策略。
+// gRPC 接口不支持删除服务，删除服务时使用 httpEndpoints 中的 HTTP 接入点
+type GRPCClient struct {
+	server  common.Server
+	policy  retryPolicy
+	timeout time.Duration
+	creds   credentials.TransportCredentials
+	http    *Client
+
+	lock  sync.Mutex
+	conns map[string]*grpc.ClientConn
+	// err 创建客户端时的错误，如证书无法加载，每次请求时返回
+	err error
+}
+
+// NewGRPCClient 根据 server 配置创建 gRPC 客户端，连接在第一次请求接入点时建立
+func NewGRPCClient(server common.Server) *GRPCClient {
+	c := &GRPCClient{
+		server:  server,
+		policy:  newRetryPolicy(server.Endpoints, server),
+		timeout: serverTimeout(server),
+		creds:   insecure.NewCredentials(),
+		conns:   map[string]*grpc.ClientConn{},
+	}
+	tlsConfig, err := server.TLSConfig()
+	c.err = err
+	if tlsConfig != nil {
+		c.creds = credentials.NewTLS(tlsConfig)
+	}
+	if len(server.HTTPEndpoints) > 0 {
+		c.http = NewClient(server.HTTP())
+	}
+	return c
+}
+
+// Close 关闭所有接入点的连接
+func (c *GRPCClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for endpoint, conn := range c.conns {
+		_ = conn.Close()
+		delete(c.conns, endpoint)
+	}
+	return nil
+}
+
+func (c *GRPCClient) conn(endpoint string) (*grpc.ClientConn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if conn, ok := c.conns[endpoint]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(c.creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		return nil, err
+	}
+	c.conns[endpoint] = conn
+	return conn, nil
+}
+
+// context 创建带有超时、请求 ID 和鉴权 token 的 context
+func (c *GRPCClient) context(requestID string) (context.Context, context.CancelFunc) {
+	if requestID == "" {
+		requestID = c.server.RequestPrefix + uuid.New().String()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx = metadata.AppendToOutgoingContext(ctx, "request-id", requestID, "x-polaris-token", c.server.AuthToken)
+	return ctx, cancel
+}
+
+// retryableStatus 接入点不可用或者超时时可以换一个接入点重试
+func retryableStatus(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// invoke 调用一元接口并检查回复中的返回码
+func (c *GRPCClient) invoke(endpoint, method, requestID string, req []byte) (bool, error) {
+	conn, err := c.conn(endpoint)
+	if err != nil {
+		return true, err
+	}
+	ctx, cancel := c.context(requestID)
+	defer cancel()
+
+	start := time.Now()
+	reply := &rawMessage{}
+	err = conn.Invoke(ctx, method, &rawMessage{data: req}, reply)
+	metrics.ObservePolarisGRPC(method, start, err)
+	if err != nil {
+		return retryableStatus(err), err
+	}
+	_, code, info, err := decodeResponse(reply.data)
+	if err != nil {
+		return false, err
+	}
+	if code != CodeSuccess {
+		return false, &Error{Code: code, Info: info}
+	}
+	return false, nil
+}
+
+// discover 通过 Discover 双向流发送一次请求并读取一个回复
+func (c *GRPCClient) discover(endpoint string, req []byte) (*message, bool, error) {
+	conn, err := c.conn(endpoint)
+	if err != nil {
+		return nil, true, err
+	}
+	ctx, cancel := c.context("")
+	defer cancel()
+
+	start := time.Now()
+	reply := &rawMessage{}
+	stream, err := conn.NewStream(ctx, discoverStreamDesc, methodDiscover)
+	if err == nil {
+		err = stream.SendMsg(&rawMessage{data: req})
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err == nil {
+		err = stream.RecvMsg(reply)
+	}
+	metrics.ObservePolarisGRPC(methodDiscover, start, err)
+	if err != nil {
+		return nil, retryableStatus(err), err
+	}
+	m, code, info, err := decodeResponse(reply.data)
+	if err != nil {
+		return nil, false, err
+	}
+	if code != CodeSuccess {
+		return nil, false, &Error{Code: code, Info: info}
+	}
+	return m, false, nil
+}
+
+// ListServices 通过 Discover 查询所有命名空间的服务，再查询每个服务的实例补全实例数量
+func (c *GRPCClient) ListServices(filter map[string]string) ([]Service, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	req := encodeDiscoverRequest(discoverTypeServices, encodeService("", "", filter))
+	var services []Service
+	err := c.policy.call("Discover services", "", true, func(endpoint string) (bool, error) {
+		m, retryable, err := c.discover(endpoint, req)
+		if err != nil {
+			return retryable, err
+		}
+		services = services[:0]
+		for _, data := range m.fields[discoverResponseServices] {
+			svc, err := decodeService(data)
+			if err != nil {
+				return false, err
+			}
+			if matchMetadata(svc.Metadata, filter) {
+				services = append(services, svc)
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range services {
+		instances, err := c.GetInstances(services[i].Namespace, services[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		services[i].TotalInstanceCount = int32(len(instances))
+		for _, ins := range instances {
+			if ins.Healthy && !ins.Isolate {
+				services[i].HealthyInstanceCount++
+			}
+		}
+	}
+	return services, nil
+}
+
+// GetInstances 通过 Discover 查询服务下的所有实例
+func (c *GRPCClient) GetInstances(namespace, service string) ([]Instance, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	req := encodeDiscoverRequest(discoverTypeInstance, encodeService(namespace, service, nil))
+	var instances []Instance
+	err := c.policy.call("Discover instances", "", true, func(endpoint string) (bool, error) {
+		m, retryable, err := c.discover(endpoint, req)
+		if err != nil {
+			return retryable, err
+		}
+		instances = instances[:0]
+		for _, data := range m.fields[discoverResponseInstances] {
+			ins, err := decodeInstance(data)
+			if err != nil {
+				return false, err
+			}
+			instances = append(instances, ins)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// DeleteInstances gRPC 接口只能逐个反注册实例，遇到第一个失败的实例时返回错误
+func (c *GRPCClient) DeleteInstances(requestID, _ string, ids []string) error {
+	if c.err != nil {
+		return c.err
+	}
+	for _, id := range ids {
+		req := encodeInstance(id)
+		err := c.policy.call("DeregisterInstance", "", true, func(endpoint string) (bool, error) {
+			return c.invoke(endpoint, methodDeregisterInstance, requestID, req)
+		})
+		if err != nil {
+			glog.Errorf("[Polaris] fail to deregister instance %s, %v", id, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteServices gRPC 接口不支持删除服务，使用 httpEndpoints 中的 HTTP 接入点删除
+func (c *GRPCClient) DeleteServices(requestID, staffname string, services []ServiceKey) ([]ServiceResult, error) {
+	if c.http == nil {
+		return nil, fmt.Errorf("deleting services is not supported by grpc, server.httpEndpoints should be set")
+	}
+	return c.http.DeleteServices(requestID, staffname, services)
+}
@@ -0,0 +1,296 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package polaris
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeServer 进程内的北极星 gRPC 服务端，记录收到的请求并按方法返回预设的回复
+type fakeServer struct {
+	lock     sync.Mutex
+	requests map[string][][]byte
+	tokens   []string
+	// reply 根据方法和请求返回回复或者错误
+	reply func(method string, req []byte) ([]byte, error)
+}
+
+func startFakeServer(t *testing.T, reply func(method string, req []byte) ([]byte, error)) (*fakeServer, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeServer{requests: map[string][][]byte{}, reply: reply}
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(f.handle))
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	return f, lis.Addr().String()
+}
+
+func (f *fakeServer) handle(_ interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	req := &rawMessage{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	f.requests[method] = append(f.requests[method], req.data)
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		f.tokens = append(f.tokens, md.Get("x-polaris-token")...)
+	}
+	f.lock.Unlock()
+
+	data, err := f.reply(method, req.data)
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(&rawMessage{data: data})
+}
+
+func (f *fakeServer) received(method string) [][]byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests[method]
+}
+
+func newTestGRPCClient(endpoint string) *GRPCClient {
+	return NewGRPCClient(common.Server{
+		Endpoints:    []string{endpoint},
+		AuthToken:    "token",
+		Protocol:     common.ProtocolGRPC,
+		RetryBackoff: 1,
+	})
+}
+
+// 以下辅助函数按照 apiservice.proto 独立构造回复，不复用被测的编码函数
+func wrapString(num protowire.Number, v string) []byte {
+	var w []byte
+	w = protowire.AppendTag(w, 1, protowire.BytesType)
+	w = protowire.AppendString(w, v)
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, w)
+}
+
+func wrapVarint(num protowire.Number, v uint64) []byte {
+	var w []byte
+	w = protowire.AppendTag(w, 1, protowire.VarintType)
+	w = protowire.AppendVarint(w, v)
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, w)
+}
+
+func nested(num protowire.Number, parts ...[]byte) []byte {
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, bytes.Join(parts, nil))
+}
+
+func mapEntry(num protowire.Number, k, v string) []byte {
+	var e []byte
+	e = protowire.AppendTag(e, 1, protowire.BytesType)
+	e = protowire.AppendString(e, k)
+	e = protowire.AppendTag(e, 2, protowire.BytesType)
+	e = protowire.AppendString(e, v)
+	return nested(num, e)
+}
+
+func okResponse(fields ...[]byte) []byte {
+	return bytes.Join(append([][]byte{wrapVarint(1, CodeSuccess), wrapString(2, "execute success")}, fields...), nil)
+}
+
+func testInstance(id, host string, port uint64, healthy bool) []byte {
+	var h uint64
+	if healthy {
+		h = 1
+	}
+	return nested(5,
+		wrapString(1, id),
+		wrapString(2, "svc"),
+		wrapString(3, "Test"),
+		wrapString(4, host),
+		wrapVarint(5, port),
+		wrapVarint(11, h),
+		wrapVarint(12, 0),
+		mapEntry(14, "env", "test"),
+	)
+}
+
+func TestGRPCDiscoverRequestBytes(t *testing.T) {
+	f, endpoint := startFakeServer(t, func(method string, req []byte) ([]byte, error) {
+		return okResponse(), nil
+	})
+	c := newTestGRPCClient(endpoint)
+	defer c.Close()
+
+	if _, err := c.GetInstances("Test", "svc"); err != nil {
+		t.Fatal(err)
+	}
+	// DiscoverRequest{type: INSTANCE, service: Service{name: "svc", namespace: "Test"}}
+	want := []byte{
+		0x08, 0x01,
+		0x12, 0x0f,
+		0x0a, 0x05, 0x0a, 0x03, 's', 'v', 'c',
+		0x12, 0x06, 0x0a, 0x04, 'T', 'e', 's', 't',
+	}
+	got := f.received(methodDiscover)
+	if len(got) != 1 || !bytes.Equal(got[0], want) {
+		t.Fatalf("discover request = %x, want %x", got, want)
+	}
+	if !reflect.DeepEqual(f.tokens, []string{"token"}) {
+		t.Fatalf("tokens = %v", f.tokens)
+	}
+}
+
+func TestGRPCDeregisterRequestBytes(t *testing.T) {
+	f, endpoint := startFakeServer(t, func(method string, req []byte) ([]byte, error) {
+		return okResponse(), nil
+	})
+	c := newTestGRPCClient(endpoint)
+	defer c.Close()
+
+	if err := c.DeleteInstances("req-1", "", []string{"ins-1", "ins-2"}); err != nil {
+		t.Fatal(err)
+	}
+	// Instance{id: "ins-1"}，每个实例一个请求
+	want := [][]byte{
+		{0x0a, 0x07, 0x0a, 0x05, 'i', 'n', 's', '-', '1'},
+		{0x0a, 0x07, 0x0a, 0x05, 'i', 'n', 's', '-', '2'},
+	}
+	if got := f.received(methodDeregisterInstance); !reflect.DeepEqual(got, want) {
+		t.Fatalf("deregister requests = %x, want %x", got, want)
+	}
+}
+
+func TestGRPCGetInstances(t *testing.T) {
+	_, endpoint := startFakeServer(t, func(method string, req []byte) ([]byte, error) {
+		return okResponse(
+			testInstance("ins-1", "127.0.0.1", 8080, true),
+			testInstance("ins-2", "127.0.0.2", 8081, false),
+		), nil
+	})
+	c := newTestGRPCClient(endpoint)
+	defer c.Close()
+
+	instances, err := c.GetInstances("Test", "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Instance{
+		{Id: "ins-1", Service: "svc", Namespace: "Test", Host: "127.0.0.1", Port: 8080, Healthy: true,
+			Metadata: map[string]string{"env": "test"}},
+		{Id: "ins-2", Service: "svc", Namespace: "Test", Host: "127.0.0.2", Port: 8081,
+			Metadata: map[string]string{"env": "test"}},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Fatalf("instances = %+v, want %+v", instances, want)
+	}
+}
+
+func TestGRPCListServices(t *testing.T) {
+	_, endpoint := startFakeServer(t, func(method string, req []byte) ([]byte, error) {
+		// 按请求类型区分查询服务列表和查询实例
+		if req[1] == discoverTypeServices {
+			return okResponse(
+				nested(9, wrapString(1, "svc"), wrapString(2, "Test"), mapEntry(3, "auto", "true"),
+					wrapString(14, "2023-01-01 00:00:00")),
+				nested(9, wrapString(1, "other"), wrapString(2, "Test")),
+			), nil
+		}
+		return okResponse(
+			testInstance("ins-1", "127.0.0.1", 8080, true),
+			testInstance("ins-2", "127.0.0.2", 8081, false),
+		), nil
+	})
+	c := newTestGRPCClient(endpoint)
+	defer c.Close()
+
+	services, err := c.ListServices(map[string]string{"auto": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Service{{
+		Name:                 "svc",
+		Namespace:            "Test",
+		Metadata:             map[string]string{"auto": "true"},
+		Mtime:                "2023-01-01 00:00:00",
+		TotalInstanceCount:   2,
+		HealthyInstanceCount: 1,
+	}}
+	if !reflect.DeepEqual(services, want) {
+		t.Fatalf("services = %+v, want %+v", services, want)
+	}
+}
+
+func TestGRPCDeleteInstances(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures 前几次请求返回的错误
+		failures []error
+		code     uint64
+		wantErr  string
+		wantReqs int
+	}{
+		{name: "success", code: CodeSuccess, wantReqs: 1},
+		{name: "retry unavailable", failures: []error{status.Error(codes.Unavailable, "down")},
+			code: CodeSuccess, wantReqs: 2},
+		{name: "no retry on other status", failures: []error{status.Error(codes.PermissionDenied, "denied")},
+			code: CodeSuccess, wantErr: "denied", wantReqs: 1},
+		{name: "no retry on error code", code: 400001, wantErr: "400001", wantReqs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lock sync.Mutex
+			calls := 0
+			f, endpoint := startFakeServer(t, func(method string, req []byte) ([]byte, error) {
+				lock.Lock()
+				defer lock.Unlock()
+				calls++
+				if calls <= len(tt.failures) {
+					return nil, tt.failures[calls-1]
+				}
+				return bytes.Join([][]byte{wrapVarint(1, tt.code), wrapString(2, "info")}, nil), nil
+			})
+			c := newTestGRPCClient(endpoint)
+			defer c.Close()
+
+			err := c.DeleteInstances("req-1", "", []string{"ins-1"})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %s", err, tt.wantErr)
+			}
+			if got := len(f.received(methodDeregisterInstance)); got != tt.wantReqs {
+				t.Fatalf("requests = %d, want %d", got, tt.wantReqs)
+			}
+		})
+	}
+}
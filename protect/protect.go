@@ -0,0 +1,187 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package protect
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+const (
+	// LabelProtect 值为 true 时，带有该标签的服务或者实例不会被清理
+	LabelProtect = "polaris-cleanup.protect"
+	// LabelTTL 覆盖 deleteLimitedTime，取值为 Go duration 格式，如 72h
+	LabelTTL = "polaris-cleanup.ttl"
+)
+
+var (
+	labelKeys = []string{LabelProtect, LabelTTL}
+	// ProtectedReason 带有保护标签的资源被跳过的原因
+	ProtectedReason = fmt.Sprintf("protected by label %s=true", LabelProtect)
+)
+
+// Labels 服务或者实例上与清理相关的标签
+type Labels struct {
+	Protect bool
+	// TTL 为 0 时使用任务配置的 deleteLimitedTime
+	TTL time.Duration
+}
+
+// Parse 从元数据中解析清理标签，无法解析的取值会被忽略
+func Parse(metadata map[string]string) Labels {
+	var labels Labels
+	if v, ok := metadata[LabelProtect]; ok {
+		protect, err := strconv.ParseBool(v)
+		if err != nil {
+			glog.Warningf("[Protect] invalid label %s=%s, err: %v", LabelProtect, v, err)
+		}
+		labels.Protect = protect
+	}
+	if v, ok := metadata[LabelTTL]; ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			glog.Warningf("[Protect] invalid label %s=%s, err: %v", LabelTTL, v, err)
+		} else {
+			labels.TTL = ttl
+		}
+	}
+	return labels
+}
+
+// Expired 资源是否已经超过保留时间，age 为资源最近一次变更距今的时间，limitTime 为任务配置的分钟数
+func (l Labels) Expired(age time.Duration, limitTime int) bool {
+	return age >= l.ttl(limitTime)
+}
+
+// ttl 资源的保留时间，没有 ttl 标签时为任务配置的分钟数
+func (l Labels) ttl(limitTime int) time.Duration {
+	if l.TTL == 0 {
+		return time.Duration(limitTime) * time.Minute
+	}
+	return l.TTL
+}
+
+// UnexpiredReason 未超过保留时间的资源被跳过的原因
+func (l Labels) UnexpiredReason(age time.Duration, limitTime int) string {
+	return fmt.Sprintf("ttl not expired, changed %v ago, ttl is %v", age.Truncate(time.Second), l.ttl(limitTime))
+}
+
+// QueryLimitTime 查询待清理资源时使用的时间（分钟），取 limitTime 和所有 ttl 标签中的较小值，
+// 查询结果再通过 FilterInstances 或者 FilterServices 按各自的 ttl 过滤，分页查询时被过滤的实例不计入 deleteLimitedNum
+func QueryLimitTime(limitTime int) (int, error) {
+	values, err := store.GetStore().LoadLabelValues(LabelTTL)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range values {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			continue
+		}
+		if minutes := int(ttl / time.Minute); minutes < limitTime {
+			limitTime = minutes
+		}
+	}
+	return limitTime, nil
+}
+
+// FilterInstances 过滤掉带有保护标签或者未超过 ttl 的实例，被过滤的实例记录到 report 中，
+// 同时返回未超过 ttl 的实例数，这些实例只是因为 QueryLimitTime 放宽了查询时间才被加载
+func FilterInstances(instances []*store.Instance, limitTime int,
+	report *common.RunReport) ([]*store.Instance, int, error) {
+	if len(instances) == 0 {
+		return instances, 0, nil
+	}
+	ids := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		ids = append(ids, ins.ID)
+	}
+	labels, err := store.GetStore().LoadInstanceLabels(ids, labelKeys)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := make([]*store.Instance, 0, len(instances))
+	unexpired := 0
+	for _, ins := range instances {
+		l := Parse(labels[ins.ID])
+		skipped := common.Candidate{
+			Kind:      common.KindInstance,
+			ID:        ins.ID,
+			Namespace: ins.Namespace,
+			Service:   ins.Service,
+			Host:      ins.Host,
+			Port:      ins.Port,
+		}
+		switch {
+		case l.Protect:
+			skipped.Reason = ProtectedReason
+		case !l.Expired(ins.Age, limitTime):
+			skipped.Reason = l.UnexpiredReason(ins.Age, limitTime)
+			unexpired++
+		default:
+			out = append(out, ins)
+			continue
+		}
+		report.AddSkipped(skipped)
+	}
+	return out, unexpired, nil
+}
+
+// FilterServices 过滤掉带有保护标签或者未超过 ttl 的服务，被过滤的服务记录到 report 中
+func FilterServices(services []*store.Service, limitTime int,
+	report *common.RunReport) ([]*store.Service, error) {
+	if len(services) == 0 {
+		return services, nil
+	}
+	ids := make([]string, 0, len(services))
+	for _, svc := range services {
+		ids = append(ids, svc.ID)
+	}
+	labels, err := store.GetStore().LoadServiceLabels(ids, labelKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*store.Service, 0, len(services))
+	for _, svc := range services {
+		l := Parse(labels[svc.ID])
+		skipped := common.Candidate{
+			Kind:      common.KindService,
+			ID:        svc.ID,
+			Namespace: svc.Namespace,
+			Service:   svc.Name,
+		}
+		switch {
+		case l.Protect:
+			skipped.Reason = ProtectedReason
+		case !l.Expired(svc.Age, limitTime):
+			skipped.Reason = l.UnexpiredReason(svc.Age, limitTime)
+		default:
+			out = append(out, svc)
+			continue
+		}
+		report.AddSkipped(skipped)
+	}
+	return out, nil
+}
@@ -0,0 +1,202 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package protect
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+// labelStore 只实现标签的查询
+type labelStore struct {
+	store.Store
+	labels map[string]map[string]string
+	values []string
+}
+
+func (l *labelStore) LoadLabelValues(string) ([]string, error) {
+	return l.values, nil
+}
+
+func (l *labelStore) LoadInstanceLabels(ids []string, _ []string) (map[string]map[string]string, error) {
+	return l.pick(ids), nil
+}
+
+func (l *labelStore) LoadServiceLabels(ids []string, _ []string) (map[string]map[string]string, error) {
+	return l.pick(ids), nil
+}
+
+func (l *labelStore) pick(ids []string) map[string]map[string]string {
+	out := map[string]map[string]string{}
+	for _, id := range ids {
+		if labels, ok := l.labels[id]; ok {
+			out[id] = labels
+		}
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		metadata map[string]string
+		want     Labels
+	}{
+		{metadata: nil, want: Labels{}},
+		{metadata: map[string]string{LabelProtect: "true"}, want: Labels{Protect: true}},
+		{metadata: map[string]string{LabelProtect: "false"}, want: Labels{}},
+		{metadata: map[string]string{LabelProtect: "yes"}, want: Labels{}},
+		{metadata: map[string]string{LabelTTL: "72h"}, want: Labels{TTL: 72 * time.Hour}},
+		{metadata: map[string]string{LabelTTL: "-1h"}, want: Labels{}},
+		{metadata: map[string]string{LabelTTL: "3d"}, want: Labels{}},
+		{metadata: map[string]string{LabelProtect: "1", LabelTTL: "30m"},
+			want: Labels{Protect: true, TTL: 30 * time.Minute}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.metadata); got != tt.want {
+			t.Errorf("Parse(%v) = %+v, want %+v", tt.metadata, got, tt.want)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	tests := []struct {
+		labels    Labels
+		age       time.Duration
+		limitTime int
+		want      bool
+	}{
+		{labels: Labels{}, age: 59 * time.Minute, limitTime: 60, want: false},
+		{labels: Labels{}, age: 60 * time.Minute, limitTime: 60, want: true},
+		{labels: Labels{TTL: 10 * time.Minute}, age: 10 * time.Minute, limitTime: 60, want: true},
+		{labels: Labels{TTL: 10 * time.Minute}, age: 9 * time.Minute, limitTime: 60, want: false},
+		{labels: Labels{TTL: 2 * time.Hour}, age: 90 * time.Minute, limitTime: 60, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.labels.Expired(tt.age, tt.limitTime); got != tt.want {
+			t.Errorf("%+v.Expired(%v, %d) = %v, want %v", tt.labels, tt.age, tt.limitTime, got, tt.want)
+		}
+	}
+}
+
+func TestQueryLimitTime(t *testing.T) {
+	tests := []struct {
+		values    []string
+		limitTime int
+		want      int
+	}{
+		{values: nil, limitTime: 60, want: 60},
+		{values: []string{"30m", "2h"}, limitTime: 60, want: 30},
+		{values: []string{"2h"}, limitTime: 60, want: 60},
+		{values: []string{"invalid", "-5m", "0s"}, limitTime: 60, want: 60},
+		{values: []string{"90s"}, limitTime: 60, want: 1},
+	}
+	for _, tt := range tests {
+		store.SetStore(&labelStore{values: tt.values})
+		got, err := QueryLimitTime(tt.limitTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("QueryLimitTime(%d) with ttl %v = %d, want %d", tt.limitTime, tt.values, got, tt.want)
+		}
+	}
+	store.SetStore(nil)
+}
+
+func TestFilterInstances(t *testing.T) {
+	store.SetStore(&labelStore{labels: map[string]map[string]string{
+		"protected": {LabelProtect: "true"},
+		"short-ttl": {LabelTTL: "10m"},
+		"long-ttl":  {LabelTTL: "3h"},
+	}})
+	defer store.SetStore(nil)
+
+	instances := []*store.Instance{
+		{ID: "old", Age: 2 * time.Hour},
+		{ID: "young", Age: 30 * time.Minute},
+		{ID: "protected", Age: 2 * time.Hour},
+		{ID: "short-ttl", Age: 30 * time.Minute},
+		{ID: "long-ttl", Age: 2 * time.Hour},
+	}
+	report := common.NewRunReport("test", false)
+	out, unexpired, err := FilterInstances(instances, 60, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, ins := range out {
+		kept = append(kept, ins.ID)
+	}
+	if want := []string{"old", "short-ttl"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if unexpired != 2 {
+		t.Errorf("unexpired = %d, want 2", unexpired)
+	}
+	skipped := map[string]string{}
+	for _, c := range report.Skipped {
+		skipped[c.ID] = c.Reason
+	}
+	want := map[string]string{
+		"young":     Labels{}.UnexpiredReason(30*time.Minute, 60),
+		"protected": ProtectedReason,
+		"long-ttl":  Labels{TTL: 3 * time.Hour}.UnexpiredReason(2*time.Hour, 60),
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped %v, want %v", skipped, want)
+	}
+}
+
+func TestFilterServices(t *testing.T) {
+	store.SetStore(&labelStore{labels: map[string]map[string]string{
+		"protected": {LabelProtect: "true"},
+		"long-ttl":  {LabelTTL: "3h"},
+	}})
+	defer store.SetStore(nil)
+
+	services := []*store.Service{
+		{ID: "old", Age: 2 * time.Hour},
+		{ID: "young", Age: 30 * time.Minute},
+		{ID: "protected", Age: 2 * time.Hour},
+		{ID: "long-ttl", Age: 2 * time.Hour},
+	}
+	report := common.NewRunReport("test", false)
+	out, err := FilterServices(services, 60, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].ID != "old" {
+		t.Errorf("kept %+v, want only old", out)
+	}
+	skipped := map[string]string{}
+	for _, c := range report.Skipped {
+		skipped[c.ID] = c.Reason
+	}
+	want := map[string]string{
+		"young":     "ttl not expired, changed 30m0s ago, ttl is 1h0m0s",
+		"protected": ProtectedReason,
+		"long-ttl":  "ttl not expired, changed 2h0m0s ago, ttl is 3h0m0s",
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped %v, want %v", skipped, want)
+	}
+}
@@ -0,0 +1,225 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package restore
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/audit"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/polaris"
+	"github.com/polarismesh/polaris-cleanup/store"
+)
+
+const (
+	// DefaultJob 未指定任务时只恢复被清理不健康实例任务删除的实例，其他任务删除的实例（如用户主动反注册后被清理的软删除实例）
+	// 需要显式指定任务才会恢复
+	DefaultJob = "DeleteUnHealthyInstance"
+
+	batchRestoreNum = 100
+	// codeExistedResource 实例已经存在的返回码
+	codeExistedResource = 400201
+)
+
+// Result 单个实例的恢复结果
+type Result struct {
+	AuditID    string
+	InstanceID string
+	Namespace  string
+	Service    string
+	Host       string
+	Port       int
+	Success    bool
+	Code       int
+	Info       string
+}
+
+// String 用于输出恢复结果
+func (r Result) String() string {
+	status := "OK"
+	if !r.Success {
+		status = "FAIL"
+	}
+	return fmt.Sprintf("[%s] audit=%s, id=%s, service=%s, namespace=%s, address=%s:%d, code=%d, info=%s",
+		status, r.AuditID, r.InstanceID, r.Service, r.Namespace, r.Host, r.Port, r.Code, r.Info)
+}
+
+// healthCheck 注册实例接口的健康检查参数
+type healthCheck struct {
+	Type      int `json:"type"`
+	Heartbeat struct {
+		Ttl int `json:"ttl"`
+	} `json:"heartbeat"`
+}
+
+type location struct {
+	Region string `json:"region,omitempty"`
+	Zone   string `json:"zone,omitempty"`
+	Campus string `json:"campus,omitempty"`
+}
+
+// registerInstance 注册实例接口的请求参数
+type registerInstance struct {
+	Id                string            `json:"id"`
+	Service           string            `json:"service"`
+	Namespace         string            `json:"namespace"`
+	VpcId             string            `json:"vpc_id,omitempty"`
+	Host              string            `json:"host"`
+	Port              int               `json:"port"`
+	Protocol          string            `json:"protocol,omitempty"`
+	Version           string            `json:"version,omitempty"`
+	Priority          int               `json:"priority"`
+	Weight            int               `json:"weight"`
+	EnableHealthCheck bool              `json:"enable_health_check"`
+	HealthCheck       *healthCheck      `json:"health_check,omitempty"`
+	Healthy           bool              `json:"healthy"`
+	Isolate           bool              `json:"isolate"`
+	Location          *location         `json:"location,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	LogicSet          string            `json:"logic_set,omitempty"`
+}
+
+// batchWriteResponse 注册实例接口的回复
+type batchWriteResponse struct {
+	Code      int    `json:"code"`
+	Info      string `json:"info"`
+	Responses []struct {
+		Code     int    `json:"code"`
+		Info     string `json:"info"`
+		Instance struct {
+			Id   string `json:"id"`
+			Host string `json:"host"`
+			Port int    `json:"port"`
+		} `json:"instance"`
+	} `json:"responses"`
+}
+
+// SelectInstances 从审计记录中挑选可以恢复的实例，同一个实例只保留最后一次删除的记录
+func SelectInstances(records []*audit.Record) []*audit.Record {
+	latest := map[string]int{}
+	var out []*audit.Record
+	for _, r := range records {
+		if r.Kind != common.KindInstance || r.Instance == nil {
+			continue
+		}
+		if i, ok := latest[r.ResourceID]; ok {
+			out[i] = r
+			continue
+		}
+		latest[r.ResourceID] = len(out)
+		out = append(out, r)
+	}
+	return out
+}
+
+// Instances 通过北极星的注册实例接口重新创建实例，保留原有的元数据、权重、协议以及健康检查配置
+func Instances(cfg common.AppConfig, records []*audit.Record) []Result {
+	client := polaris.NewClient(cfg.Server.HTTP())
+	results := make([]Result, 0, len(records))
+	for i := 0; i < len(records); i += batchRestoreNum {
+		j := i + batchRestoreNum
+		if j > len(records) {
+			j = len(records)
+		}
+		results = append(results, restoreBatch(client, records[i:j])...)
+	}
+	return results
+}
+
+func restoreBatch(client *polaris.Client, records []*audit.Record) []Result {
+	results := make([]Result, 0, len(records))
+	params := make([]registerInstance, 0, len(records))
+	for _, r := range records {
+		results = append(results, Result{
+			AuditID:    r.ID,
+			InstanceID: r.Instance.ID,
+			Namespace:  r.Instance.Namespace,
+			Service:    r.Instance.Service,
+			Host:       r.Instance.Host,
+			Port:       r.Instance.Port,
+		})
+		params = append(params, toRegisterInstance(r.Instance))
+	}
+
+	resp, err := sendRegisterRequest(client, params)
+	if err != nil {
+		for i := range results {
+			results[i].Info = err.Error()
+		}
+		return results
+	}
+	for i := range results {
+		if i >= len(resp.Responses) {
+			results[i].Code = resp.Code
+			results[i].Info = resp.Info
+			continue
+		}
+		single := resp.Responses[i]
+		results[i].Code = single.Code
+		results[i].Info = single.Info
+		results[i].Success = single.Code == polaris.CodeSuccess || single.Code == codeExistedResource
+	}
+	return results
+}
+
+func toRegisterInstance(ins *store.InstanceDetail) registerInstance {
+	param := registerInstance{
+		Id:                ins.ID,
+		Service:           ins.Service,
+		Namespace:         ins.Namespace,
+		VpcId:             ins.VpcID,
+		Host:              ins.Host,
+		Port:              ins.Port,
+		Protocol:          ins.Protocol,
+		Version:           ins.Version,
+		Priority:          ins.Priority,
+		Weight:            ins.Weight,
+		EnableHealthCheck: ins.EnableHealthCheck,
+		Healthy:           ins.Healthy,
+		Isolate:           ins.Isolate,
+		Metadata:          ins.Metadata,
+		LogicSet:          ins.LogicSet,
+	}
+	if ins.EnableHealthCheck {
+		param.HealthCheck = &healthCheck{Type: ins.HealthCheckType}
+		param.HealthCheck.Heartbeat.Ttl = ins.HeartbeatTTL
+	}
+	if ins.Region != "" || ins.Zone != "" || ins.Campus != "" {
+		param.Location = &location{Region: ins.Region, Zone: ins.Zone, Campus: ins.Campus}
+	}
+	return param
+}
+
+// sendRegisterRequest 注册实例不是幂等的请求，失败后不重试，已经存在的实例按恢复成功处理
+func sendRegisterRequest(client *polaris.Client, params []registerInstance) (*batchWriteResponse, error) {
+	var response batchWriteResponse
+	err := client.Do(polaris.Request{
+		Method:    http.MethodPost,
+		Path:      "/naming/v1/instances",
+		Body:      params,
+		Staffname: "清理实例恢复",
+	}, &response)
+	// 部分实例注册失败时整体返回错误，由调用方逐个检查子请求的结果
+	if err != nil && len(response.Responses) == 0 {
+		return nil, err
+	}
+	glog.Infof("[Restore] register instances, code:%d, info:%s", response.Code, response.Info)
+	return &response, nil
+}
@@ -0,0 +1,856 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/metrics"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// boltLockTimeout 等待文件锁的时间，北极星单机版运行时会一直持有数据文件的锁
+	boltLockTimeout = 5 * time.Second
+	// boltCompactTxSize 压缩时每个事务写入的最大字节数
+	boltCompactTxSize = 64 << 20
+
+	bucketInstance = "instance"
+	bucketService  = "service"
+
+	fieldProto      = "proto"
+	fieldServiceID  = "serviceid"
+	fieldValid      = "valid"
+	fieldCreateTime = "createtime"
+	fieldModifyTime = "modifytime"
+	fieldName       = "name"
+	fieldNamespace  = "namespace"
+	fieldPorts      = "ports"
+	fieldBusiness   = "business"
+	fieldDepartment = "department"
+	fieldComment    = "comment"
+	fieldOwner      = "owner"
+	fieldRevision   = "revision"
+	fieldMeta       = "meta"
+)
+
+// BoltStore 操作北极星单机版 BoltDB 数据文件的工具类，北极星单机版运行时会锁住数据文件，
+// 需要在 server 停止时操作，或者操作数据文件的快照副本
+type BoltStore struct {
+	path    string
+	compact bool
+	db      *bolt.DB
+	// deleted 打开后删除的记录数
+	deleted int64
+}
+
+// NewBoltStore 打开北极星单机版的数据文件，compact 为 true 时关闭前压缩有删除的数据文件
+func NewBoltStore(path string, compact bool) (*BoltStore, error) {
+	if path == "" {
+		return nil, errors.New("boltdb path is empty")
+	}
+	if _, err := os.Stat(path); err != nil {
+		glog.Errorf("stat boltdb(%s) err: %s", path, err.Error())
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltLockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("boltdb(%s) is locked by another process, stop the polaris server "+
+			"or use a snapshot copy of the data file", path)
+	}
+	if err != nil {
+		glog.Errorf("open boltdb(%s) err: %s", path, err.Error())
+		return nil, err
+	}
+	return &BoltStore{path: path, compact: compact, db: db}, nil
+}
+
+// Ping 检查数据文件是否可以访问
+func (b *BoltStore) Ping() error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+// Close 关闭数据文件，开启了压缩并且有删除时先压缩数据文件
+func (b *BoltStore) Close() {
+	if b.db == nil {
+		return
+	}
+	if b.compact && atomic.LoadInt64(&b.deleted) > 0 {
+		if err := b.compactFile(); err != nil {
+			glog.Errorf("[BoltStore] compact %s err: %s", b.path, err.Error())
+		}
+	}
+	if b.db != nil {
+		_ = b.db.Close()
+		b.db = nil
+	}
+}
+
+// compactFile 将数据复制到新文件中再替换原文件，回收删除记录占用的空间
+func (b *BoltStore) compactFile() error {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".compact"
+	_ = os.Remove(tmp)
+	dst, err := bolt.Open(tmp, info.Mode(), &bolt.Options{Timeout: boltLockTimeout})
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, b.db, boltCompactTxSize); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = b.db.Close()
+	b.db = nil
+	if err := os.Rename(tmp, b.path); err != nil {
+		return err
+	}
+	if compacted, err := os.Stat(b.path); err == nil {
+		glog.Infof("[BoltStore] compact %s from %d to %d bytes", b.path, info.Size(), compacted.Size())
+	}
+	return nil
+}
+
+// view 执行只读事务，并记录监控指标
+func (b *BoltStore) view(operation string, fn func(tx *bolt.Tx) error) error {
+	start := time.Now()
+	err := b.db.View(fn)
+	metrics.ObserveBoltDB(operation, start, err)
+	return err
+}
+
+// update 执行读写事务，并记录监控指标
+func (b *BoltStore) update(operation string, fn func(tx *bolt.Tx) error) error {
+	start := time.Now()
+	err := b.db.Update(fn)
+	metrics.ObserveBoltDB(operation, start, err)
+	return err
+}
+
+// forEachRecord 依次处理 bucket 中的记录，每条记录为以 ID 为 key 的子 bucket，fn 返回 false 时停止
+func forEachRecord(tx *bolt.Tx, bucket string, fn func(id string, record *bolt.Bucket) (bool, error)) error {
+	return forEachRecordAfter(tx, bucket, "", fn)
+}
+
+// forEachRecordAfter 按 key 的顺序依次处理 ID 大于 after 的记录，fn 返回 false 时停止
+func forEachRecordAfter(tx *bolt.Tx, bucket, after string,
+	fn func(id string, record *bolt.Bucket) (bool, error)) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	c := b.Cursor()
+	for k, v := c.Seek([]byte(after)); k != nil; k, v = c.Next() {
+		if v != nil || string(k) <= after {
+			continue
+		}
+		next, err := fn(string(k), b.Bucket(k))
+		if err != nil || !next {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRecords 删除 bucket 中软删除的记录，match 为 nil 时按 ID 删除，返回删除的数量
+func deleteRecords(tx *bolt.Tx, bucket string, ids []string, match func(record *bolt.Bucket) bool) (int, error) {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return 0, nil
+	}
+	var keys []string
+	if match == nil {
+		keys = ids
+	} else {
+		err := forEachRecord(tx, bucket, func(id string, record *bolt.Bucket) (bool, error) {
+			if match(record) {
+				keys = append(keys, id)
+			}
+			return true, nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	deleted := 0
+	for _, key := range keys {
+		record := b.Bucket([]byte(key))
+		if record == nil {
+			continue
+		}
+		valid, err := getBool(record, fieldValid)
+		if err != nil {
+			return deleted, err
+		}
+		if valid {
+			continue
+		}
+		if err := b.DeleteBucket([]byte(key)); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// boltInstance 数据文件中的实例
+type boltInstance struct {
+	detail    *InstanceDetail
+	serviceID string
+	valid     bool
+}
+
+func decodeInstanceRecord(id string, record *bolt.Bucket) (*boltInstance, error) {
+	ins := &boltInstance{detail: &InstanceDetail{ID: id}}
+	var err error
+	if ins.serviceID, err = getString(record, fieldServiceID); err != nil {
+		return nil, err
+	}
+	if ins.valid, err = getBool(record, fieldValid); err != nil {
+		return nil, err
+	}
+	if ins.detail.Mtime, err = getTime(record, fieldModifyTime); err != nil {
+		return nil, err
+	}
+	proto, err := getValue(record, fieldProto, boltTypeProtobuf)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeInstanceProto(proto, ins.detail); err != nil {
+		return nil, fmt.Errorf("decode instance %s err: %v", id, err)
+	}
+	ins.detail.ID = id
+	if !ins.valid {
+		ins.detail.Flag = 1
+	}
+	return ins, nil
+}
+
+func decodeServiceRecord(id string, record *bolt.Bucket) (*ServiceDetail, error) {
+	svc := &ServiceDetail{ID: id}
+	fields := map[string]*string{
+		fieldName:       &svc.Name,
+		fieldNamespace:  &svc.Namespace,
+		fieldPorts:      &svc.Ports,
+		fieldBusiness:   &svc.Business,
+		fieldDepartment: &svc.Department,
+		fieldComment:    &svc.Comment,
+		fieldOwner:      &svc.Owner,
+		fieldRevision:   &svc.Revision,
+	}
+	var err error
+	for field, value := range fields {
+		if *value, err = getString(record, field); err != nil {
+			return nil, err
+		}
+	}
+	valid, err := getBool(record, fieldValid)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		svc.Flag = 1
+	}
+	if svc.Ctime, err = getTime(record, fieldCreateTime); err != nil {
+		return nil, err
+	}
+	if svc.Mtime, err = getTime(record, fieldModifyTime); err != nil {
+		return nil, err
+	}
+	svc.Metadata = getMap(record, fieldMeta)
+	return svc, nil
+}
+
+// loadServices 加载所有服务，北极星单机版的数据量不大，直接全部加载
+func loadServices(tx *bolt.Tx) (map[string]*ServiceDetail, error) {
+	out := map[string]*ServiceDetail{}
+	err := forEachRecord(tx, bucketService, func(id string, record *bolt.Bucket) (bool, error) {
+		svc, err := decodeServiceRecord(id, record)
+		if err != nil {
+			return false, err
+		}
+		out[id] = svc
+		return true, nil
+	})
+	return out, err
+}
+
+// forEachInstance 按 ID 的顺序依次处理 ID 大于 after 的实例，实例所属服务不存在时 service 为 nil，fn 返回 false 时停止
+func forEachInstance(tx *bolt.Tx, after string, fn func(ins *boltInstance, service *ServiceDetail) bool) error {
+	services, err := loadServices(tx)
+	if err != nil {
+		return err
+	}
+	return forEachRecordAfter(tx, bucketInstance, after, func(id string, record *bolt.Bucket) (bool, error) {
+		ins, err := decodeInstanceRecord(id, record)
+		if err != nil {
+			return false, err
+		}
+		return fn(ins, services[ins.serviceID]), nil
+	})
+}
+
+// toInstance 转换为待清理实例，与 MySQL 一样优先使用所属服务的名称和命名空间
+func toInstance(ins *boltInstance, service *ServiceDetail) *Instance {
+	if service != nil {
+		ins.detail.Service, ins.detail.Namespace = service.Name, service.Namespace
+	}
+	return &Instance{
+		ID:        ins.detail.ID,
+		Service:   ins.detail.Service,
+		Namespace: ins.detail.Namespace,
+		Host:      ins.detail.Host,
+		Port:      ins.detail.Port,
+		Age:       time.Since(ins.detail.Mtime).Truncate(time.Second),
+	}
+}
+
+// loadInstances 按 ID 的顺序加载 ID 大于 lastID、limitTime 分钟内没有变更过并且满足 match 的实例
+func (b *BoltStore) loadInstances(operation, lastID string, limitTime, limitNum int,
+	match func(ins *boltInstance, service *ServiceDetail) bool) ([]*Instance, error) {
+	deadline := time.Now().Add(-time.Duration(limitTime) * time.Minute)
+	out := make([]*Instance, 0)
+	err := b.view(operation, func(tx *bolt.Tx) error {
+		return forEachInstance(tx, lastID, func(ins *boltInstance, service *ServiceDetail) bool {
+			if len(out) >= limitNum {
+				return false
+			}
+			if ins.detail.Mtime.After(deadline) || !match(ins, service) {
+				return true
+			}
+			out = append(out, toInstance(ins, service))
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LoadInvalidInstancesAfter 按 ID 的顺序加载 ID 大于 lastID 的一页失效实例
+func (b *BoltStore) LoadInvalidInstancesAfter(lastID string, limitTime, limitNum int) ([]*Instance, error) {
+	out, err := b.loadInstances("LoadInvalidInstancesAfter", lastID, limitTime, limitNum,
+		func(ins *boltInstance, _ *ServiceDetail) bool {
+			return !ins.valid
+		})
+	if err != nil {
+		glog.Errorf("[BoltStore] load all invalid instances err: %s", err.Error())
+		return nil, err
+	}
+
+	glog.Infof("[BoltStore] get invalid instances after id(%s) count: %d", lastID, len(out))
+	return out, nil
+}
+
+// LoadUnhealthyInstancesAfter 按 ID 的顺序加载 ID 大于 lastID 的一页长期不健康的实例
+func (b *BoltStore) LoadUnhealthyInstancesAfter(lastID string, limitTime, limitNum int) ([]*Instance, error) {
+	out, err := b.loadInstances("LoadUnhealthyInstancesAfter", lastID, limitTime, limitNum,
+		func(ins *boltInstance, _ *ServiceDetail) bool {
+			return ins.valid && ins.detail.EnableHealthCheck && !ins.detail.Healthy
+		})
+	if err != nil {
+		glog.Errorf("[BoltStore] load unhealthy instances err: %s", err.Error())
+		return nil, err
+	}
+
+	glog.Infof("[BoltStore] get unhealthy instances after id(%s) count: %d", lastID, len(out))
+	return out, nil
+}
+
+// LoadNamespaceInstances 加载命名空间下 limitTime 分钟内没有变更过的实例
+func (b *BoltStore) LoadNamespaceInstances(namespace string, limitTime, limitNum int) ([]*Instance, error) {
+	out, err := b.loadInstances("LoadNamespaceInstances", "", limitTime, limitNum,
+		func(ins *boltInstance, service *ServiceDetail) bool {
+			return ins.valid && service != nil && service.Namespace == namespace
+		})
+	if err != nil {
+		glog.Errorf("[BoltStore] load namespace(%s) instances err: %s", namespace, err.Error())
+		return nil, err
+	}
+
+	glog.Infof("[BoltStore] get namespace(%s) instances count: %d", namespace, len(out))
+	return out, nil
+}
+
+// CountServiceInstances 统计每个服务下未删除的实例数
+func (b *BoltStore) CountServiceInstances() (map[ServiceKey]InstanceCount, error) {
+	out := make(map[ServiceKey]InstanceCount)
+	err := b.view("CountServiceInstances", func(tx *bolt.Tx) error {
+		return forEachInstance(tx, "", func(ins *boltInstance, service *ServiceDetail) bool {
+			if !ins.valid || service == nil {
+				return true
+			}
+			key := ServiceKey{Namespace: service.Namespace, Service: service.Name}
+			count := out[key]
+			count.Total++
+			if ins.detail.Healthy && !ins.detail.Isolate {
+				count.Healthy++
+			}
+			out[key] = count
+			return true
+		})
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] count service instances err: %s", err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// CleanInvalidInstanceList 清理失效的实例列表
+func (b *BoltStore) CleanInvalidInstanceList(instanceIds []string) error {
+	if len(instanceIds) == 0 {
+		return errors.New("missing id")
+	}
+
+	err := b.update("CleanInvalidInstanceList", func(tx *bolt.Tx) error {
+		deleted, err := deleteRecords(tx, bucketInstance, instanceIds, nil)
+		atomic.AddInt64(&b.deleted, int64(deleted))
+		return err
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] clean invalid instance(%s) err: %s", instanceIds, err.Error())
+		return err
+	}
+
+	glog.Info("[BoltStore] clean invalid instance: ", instanceIds)
+
+	return nil
+}
+
+// LoadAllInvalidServices 加载所有软删除的服务，仍有未删除实例的服务不会被加载
+func (b *BoltStore) LoadAllInvalidServices(limitTime, limitNum int) ([]*Service, error) {
+	deadline := time.Now().Add(-time.Duration(limitTime) * time.Minute)
+	out := make([]*Service, 0)
+	err := b.view("LoadAllInvalidServices", func(tx *bolt.Tx) error {
+		inUse := map[string]bool{}
+		err := forEachRecord(tx, bucketInstance, func(id string, record *bolt.Bucket) (bool, error) {
+			valid, err := getBool(record, fieldValid)
+			if err != nil || !valid {
+				return err == nil, err
+			}
+			serviceID, err := getString(record, fieldServiceID)
+			inUse[serviceID] = true
+			return err == nil, err
+		})
+		if err != nil {
+			return err
+		}
+		return forEachRecord(tx, bucketService, func(id string, record *bolt.Bucket) (bool, error) {
+			if len(out) >= limitNum {
+				return false, nil
+			}
+			svc, err := decodeServiceRecord(id, record)
+			if err != nil {
+				return false, err
+			}
+			if svc.Flag == 1 && !svc.Mtime.After(deadline) && !inUse[id] {
+				out = append(out, &Service{
+					ID:        id,
+					Name:      svc.Name,
+					Namespace: svc.Namespace,
+					Age:       time.Since(svc.Mtime).Truncate(time.Second),
+				})
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] load all invalid services err: %s", err.Error())
+		return nil, err
+	}
+
+	glog.Infof("[BoltStore] get all invalid services count: %d", len(out))
+	return out, nil
+}
+
+// CleanInvalidServiceList 清理软删除的服务列表，服务的元数据保存在服务的记录中，随服务一起删除
+func (b *BoltStore) CleanInvalidServiceList(serviceIds []string) error {
+	if len(serviceIds) == 0 {
+		return errors.New("missing id")
+	}
+
+	err := b.update("CleanInvalidServiceList", func(tx *bolt.Tx) error {
+		deleted, err := deleteRecords(tx, bucketService, serviceIds, nil)
+		atomic.AddInt64(&b.deleted, int64(deleted))
+		return err
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] clean invalid service(%s) err: %s", serviceIds, err.Error())
+		return err
+	}
+
+	glog.Info("[BoltStore] clean invalid service: ", serviceIds)
+
+	return nil
+}
+
+// LoadAllInvalidRules 加载某类治理规则中所有软删除的规则
+func (b *BoltStore) LoadAllInvalidRules(kind string, limitTime, limitNum int) ([]string, error) {
+	t, err := getRuleTable(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(-time.Duration(limitTime) * time.Minute)
+	out := make([]string, 0)
+	err = b.view("LoadAllInvalidRules", func(tx *bolt.Tx) error {
+		seen := map[string]bool{}
+		return forEachRecord(tx, t.bucket, func(id string, record *bolt.Bucket) (bool, error) {
+			if len(out) >= limitNum {
+				return false, nil
+			}
+			valid, err := getBool(record, fieldValid)
+			if err != nil {
+				return false, err
+			}
+			mtime, err := getTime(record, fieldModifyTime)
+			if err != nil {
+				return false, err
+			}
+			if valid || mtime.After(deadline) {
+				return true, nil
+			}
+			if t.idField != "" {
+				if id, err = getString(record, t.idField); err != nil {
+					return false, err
+				}
+			}
+			if !seen[id] {
+				seen[id] = true
+				out = append(out, id)
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] load all invalid %s rules err: %s", kind, err.Error())
+		return nil, err
+	}
+
+	glog.Infof("[BoltStore] get all invalid %s rules count: %d", kind, len(out))
+	return out, nil
+}
+
+// ExistingRuleKinds 返回数据文件中有对应 bucket 的治理规则类型，没有 bucket 说明没有这类规则
+func (b *BoltStore) ExistingRuleKinds() ([]string, error) {
+	var kinds []string
+	err := b.view("ExistingRuleKinds", func(tx *bolt.Tx) error {
+		kinds = filterRuleKinds(func(t ruleTable) bool {
+			return tx.Bucket([]byte(t.bucket)) != nil
+		})
+		return nil
+	})
+	return kinds, err
+}
+
+// CleanInvalidRuleList 清理某类治理规则中软删除的规则
+func (b *BoltStore) CleanInvalidRuleList(kind string, ruleIds []string) error {
+	if len(ruleIds) == 0 {
+		return errors.New("missing id")
+	}
+	t, err := getRuleTable(kind)
+	if err != nil {
+		return err
+	}
+
+	var match func(record *bolt.Bucket) bool
+	if t.idField != "" {
+		ids := make(map[string]bool, len(ruleIds))
+		for _, id := range ruleIds {
+			ids[id] = true
+		}
+		match = func(record *bolt.Bucket) bool {
+			id, err := getString(record, t.idField)
+			return err == nil && ids[id]
+		}
+	}
+	err = b.update("CleanInvalidRuleList", func(tx *bolt.Tx) error {
+		deleted, err := deleteRecords(tx, t.bucket, ruleIds, match)
+		atomic.AddInt64(&b.deleted, int64(deleted))
+		return err
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] clean invalid %s rules(%s) err: %s", kind, ruleIds, err.Error())
+		return err
+	}
+
+	glog.Infof("[BoltStore] clean invalid %s rules: %v", kind, ruleIds)
+
+	return nil
+}
+
+// RecheckInvalidInstances 复查失效的实例，返回仍然满足清理条件的实例 ID
+func (b *BoltStore) RecheckInvalidInstances(instanceIds []string, limitTime int) ([]string, error) {
+	return b.recheckInstances("RecheckInvalidInstances", instanceIds, limitTime,
+		func(ins *boltInstance, _ *ServiceDetail) bool {
+			return !ins.valid
+		})
+}
+
+// RecheckUnhealthyInstances 复查长期不健康的实例，返回仍然满足清理条件的实例 ID
+func (b *BoltStore) RecheckUnhealthyInstances(instanceIds []string, limitTime int) ([]string, error) {
+	return b.recheckInstances("RecheckUnhealthyInstances", instanceIds, limitTime,
+		func(ins *boltInstance, _ *ServiceDetail) bool {
+			return ins.valid && ins.detail.EnableHealthCheck && !ins.detail.Healthy
+		})
+}
+
+// RecheckNamespaceInstances 复查命名空间下 limitTime 分钟内没有变更过的实例，返回仍然满足清理条件的实例 ID
+func (b *BoltStore) RecheckNamespaceInstances(namespace string, instanceIds []string, limitTime int) ([]string, error) {
+	return b.recheckInstances("RecheckNamespaceInstances", instanceIds, limitTime,
+		func(ins *boltInstance, service *ServiceDetail) bool {
+			return ins.valid && service != nil && service.Namespace == namespace
+		})
+}
+
+// recheckInstances 按 ID 复查 limitTime 分钟内没有变更过并且满足 match 的实例
+func (b *BoltStore) recheckInstances(operation string, instanceIds []string, limitTime int,
+	match func(ins *boltInstance, service *ServiceDetail) bool) ([]string, error) {
+	deadline := time.Now().Add(-time.Duration(limitTime) * time.Minute)
+	out := make([]string, 0, len(instanceIds))
+	err := b.view(operation, func(tx *bolt.Tx) error {
+		services, err := loadServices(tx)
+		if err != nil {
+			return err
+		}
+		instances := tx.Bucket([]byte(bucketInstance))
+		if instances == nil {
+			return nil
+		}
+		for _, id := range instanceIds {
+			record := instances.Bucket([]byte(id))
+			if record == nil {
+				continue
+			}
+			ins, err := decodeInstanceRecord(id, record)
+			if err != nil {
+				return err
+			}
+			if !ins.detail.Mtime.After(deadline) && match(ins, services[ins.serviceID]) {
+				out = append(out, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] %s err: %s", operation, err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// RecheckInvalidServices 复查软删除的服务，返回仍然满足清理条件的服务 ID
+func (b *BoltStore) RecheckInvalidServices(serviceIds []string, limitTime int) ([]string, error) {
+	wanted := make(map[string]bool, len(serviceIds))
+	for _, id := range serviceIds {
+		wanted[id] = true
+	}
+	services, err := b.LoadAllInvalidServices(limitTime, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(serviceIds))
+	for _, svc := range services {
+		if wanted[svc.ID] {
+			out = append(out, svc.ID)
+		}
+	}
+	return out, nil
+}
+
+// LoadInstanceDetails 加载实例的完整信息，包括健康检查配置和元数据
+func (b *BoltStore) LoadInstanceDetails(instanceIds []string) ([]*InstanceDetail, error) {
+	if len(instanceIds) == 0 {
+		return nil, nil
+	}
+
+	out := make([]*InstanceDetail, 0, len(instanceIds))
+	err := b.view("LoadInstanceDetails", func(tx *bolt.Tx) error {
+		services, err := loadServices(tx)
+		if err != nil {
+			return err
+		}
+		instances := tx.Bucket([]byte(bucketInstance))
+		if instances == nil {
+			return nil
+		}
+		for _, id := range instanceIds {
+			record := instances.Bucket([]byte(id))
+			if record == nil {
+				continue
+			}
+			ins, err := decodeInstanceRecord(id, record)
+			if err != nil {
+				return err
+			}
+			toInstance(ins, services[ins.serviceID])
+			out = append(out, ins.detail)
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] load instance details err: %s", err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// LoadServiceDetails 加载服务的完整信息，包括元数据
+func (b *BoltStore) LoadServiceDetails(serviceIds []string) ([]*ServiceDetail, error) {
+	if len(serviceIds) == 0 {
+		return nil, nil
+	}
+
+	out := make([]*ServiceDetail, 0, len(serviceIds))
+	err := b.view("LoadServiceDetails", func(tx *bolt.Tx) error {
+		services := tx.Bucket([]byte(bucketService))
+		if services == nil {
+			return nil
+		}
+		for _, id := range serviceIds {
+			record := services.Bucket([]byte(id))
+			if record == nil {
+				continue
+			}
+			svc, err := decodeServiceRecord(id, record)
+			if err != nil {
+				return err
+			}
+			out = append(out, svc)
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] load service details err: %s", err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// LoadInstanceLabels 加载实例及其所属服务上指定 key 的元数据，同一个 key 以实例上的为准
+func (b *BoltStore) LoadInstanceLabels(ids []string, keys []string) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string, len(ids))
+	err := b.view("LoadInstanceLabels", func(tx *bolt.Tx) error {
+		services, err := loadServices(tx)
+		if err != nil {
+			return err
+		}
+		instances := tx.Bucket([]byte(bucketInstance))
+		if instances == nil {
+			return nil
+		}
+		for _, id := range ids {
+			record := instances.Bucket([]byte(id))
+			if record == nil {
+				continue
+			}
+			ins, err := decodeInstanceRecord(id, record)
+			if err != nil {
+				return err
+			}
+			if service := services[ins.serviceID]; service != nil {
+				addLabels(out, id, service.Metadata, keys)
+			}
+			addLabels(out, id, ins.detail.Metadata, keys)
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] load instance labels err: %s", err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// LoadServiceLabels 加载服务上指定 key 的元数据
+func (b *BoltStore) LoadServiceLabels(ids []string, keys []string) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string, len(ids))
+	details, err := b.LoadServiceDetails(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, svc := range details {
+		addLabels(out, svc.ID, svc.Metadata, keys)
+	}
+	return out, nil
+}
+
+// LoadLabelValues 加载服务和实例元数据中某个 key 的所有取值
+func (b *BoltStore) LoadLabelValues(key string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	add := func(metadata map[string]string) {
+		if value, ok := metadata[key]; ok && !seen[value] {
+			seen[value] = true
+			out = append(out, value)
+		}
+	}
+	err := b.view("LoadLabelValues", func(tx *bolt.Tx) error {
+		services, err := loadServices(tx)
+		if err != nil {
+			return err
+		}
+		for _, svc := range services {
+			add(svc.Metadata)
+		}
+		return forEachRecord(tx, bucketInstance, func(id string, record *bolt.Bucket) (bool, error) {
+			ins, err := decodeInstanceRecord(id, record)
+			if err != nil {
+				return false, err
+			}
+			add(ins.detail.Metadata)
+			return true, nil
+		})
+	})
+	if err != nil {
+		glog.Errorf("[BoltStore] load label(%s) values err: %s", key, err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// addLabels 将 metadata 中指定 key 的元数据写入 out
+func addLabels(out map[string]map[string]string, id string, metadata map[string]string, keys []string) {
+	for _, key := range keys {
+		value, ok := metadata[key]
+		if !ok {
+			continue
+		}
+		if out[id] == nil {
+			out[id] = map[string]string{}
+		}
+		out[id][key] = value
+	}
+}
@@ -0,0 +1,288 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 北极星单机版中字段值的第一个字节为值的类型
+const (
+	boltTypeString   byte = 1
+	boltTypeBool     byte = 2
+	boltTypeTime     byte = 3
+	boltTypeProtobuf byte = 4
+)
+
+// 北极星实例（apiservice.Instance）中用到的字段编号
+const (
+	wrapperValue              protowire.Number = 1
+	mapEntryKey               protowire.Number = 1
+	mapEntryValue             protowire.Number = 2
+	instanceID                protowire.Number = 1
+	instanceService           protowire.Number = 2
+	instanceNamespace         protowire.Number = 3
+	instanceHost              protowire.Number = 4
+	instancePort              protowire.Number = 5
+	instanceProtocol          protowire.Number = 6
+	instanceVersion           protowire.Number = 7
+	instancePriority          protowire.Number = 8
+	instanceWeight            protowire.Number = 9
+	instanceHealthCheck       protowire.Number = 10
+	instanceHealthy           protowire.Number = 11
+	instanceIsolate           protowire.Number = 12
+	instanceLocation          protowire.Number = 13
+	instanceMetadata          protowire.Number = 14
+	instanceLogicSet          protowire.Number = 15
+	instanceCtime             protowire.Number = 16
+	instanceRevision          protowire.Number = 18
+	instanceEnableHealthCheck protowire.Number = 20
+	instanceVpcID             protowire.Number = 21
+	healthCheckType           protowire.Number = 1
+	healthCheckHeartbeat      protowire.Number = 2
+	heartbeatTTL              protowire.Number = 1
+	locationRegion            protowire.Number = 1
+	locationZone              protowire.Number = 2
+	locationCampus            protowire.Number = 3
+)
+
+// boltTimeLayout 实例 ctime 的格式
+const boltTimeLayout = "2006-01-02 15:04:05"
+
+// getValue 读取记录中的字段，字段不存在时返回 nil
+func getValue(record *bolt.Bucket, field string, typ byte) ([]byte, error) {
+	v := record.Get([]byte(field))
+	if len(v) == 0 {
+		return nil, nil
+	}
+	if v[0] != typ {
+		return nil, fmt.Errorf("invalid type of field %s, want %d, actual is %d", field, typ, v[0])
+	}
+	return v[1:], nil
+}
+
+func getString(record *bolt.Bucket, field string) (string, error) {
+	v, err := getValue(record, field, boltTypeString)
+	return string(v), err
+}
+
+func getBool(record *bolt.Bucket, field string) (bool, error) {
+	v, err := getValue(record, field, boltTypeBool)
+	if err != nil || len(v) == 0 {
+		return false, err
+	}
+	return v[0] > 0, nil
+}
+
+func getTime(record *bolt.Bucket, field string) (time.Time, error) {
+	v, err := getValue(record, field, boltTypeTime)
+	if err != nil || len(v) == 0 {
+		return time.Unix(0, 0), err
+	}
+	if len(v) < 8 {
+		return time.Unix(0, 0), fmt.Errorf("invalid time field %s", field)
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(v))), nil
+}
+
+// getMap 读取记录中以子 bucket 保存的 map 字段
+func getMap(record *bolt.Bucket, field string) map[string]string {
+	b := record.Bucket([]byte(field))
+	if b == nil {
+		return nil
+	}
+	var out map[string]string
+	_ = b.ForEach(func(k, v []byte) error {
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[string(k)] = string(v)
+		return nil
+	})
+	return out
+}
+
+// walkFields 依次处理消息中的字段，varint 类型的值通过 x 传入，bytes 类型的值通过 v 传入，其他类型的字段被跳过
+func walkFields(b []byte, fn func(num protowire.Number, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var err error
+		switch typ {
+		case protowire.VarintType:
+			var x uint64
+			x, n = protowire.ConsumeVarint(b)
+			if n >= 0 {
+				err = fn(num, nil, x)
+			}
+		case protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				err = fn(num, v, 0)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// wrapper google.protobuf 中 StringValue、UInt32Value、BoolValue 等包装类型的值
+type wrapper struct {
+	s string
+	x uint64
+}
+
+func decodeWrapper(b []byte) (wrapper, error) {
+	var w wrapper
+	err := walkFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		if num == wrapperValue {
+			w.s, w.x = string(v), x
+		}
+		return nil
+	})
+	return w, err
+}
+
+// decodeInstanceProto 解码记录中 proto 字段保存的实例，写入 ins
+func decodeInstanceProto(b []byte, ins *InstanceDetail) error {
+	return walkFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		switch num {
+		case instanceHealthCheck:
+			return decodeHealthCheck(v, ins)
+		case instanceLocation:
+			return decodeLocation(v, ins)
+		case instanceMetadata:
+			var k, val string
+			err := walkFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+				switch num {
+				case mapEntryKey:
+					k = string(v)
+				case mapEntryValue:
+					val = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if ins.Metadata == nil {
+				ins.Metadata = map[string]string{}
+			}
+			ins.Metadata[k] = val
+			return nil
+		}
+		if v == nil {
+			return nil
+		}
+		w, err := decodeWrapper(v)
+		if err != nil {
+			return err
+		}
+		switch num {
+		case instanceID:
+			ins.ID = w.s
+		case instanceService:
+			ins.Service = w.s
+		case instanceNamespace:
+			ins.Namespace = w.s
+		case instanceVpcID:
+			ins.VpcID = w.s
+		case instanceHost:
+			ins.Host = w.s
+		case instancePort:
+			ins.Port = int(w.x)
+		case instanceProtocol:
+			ins.Protocol = w.s
+		case instanceVersion:
+			ins.Version = w.s
+		case instancePriority:
+			ins.Priority = int(w.x)
+		case instanceWeight:
+			ins.Weight = int(w.x)
+		case instanceHealthy:
+			ins.Healthy = w.x != 0
+		case instanceIsolate:
+			ins.Isolate = w.x != 0
+		case instanceEnableHealthCheck:
+			ins.EnableHealthCheck = w.x != 0
+		case instanceLogicSet:
+			ins.LogicSet = w.s
+		case instanceRevision:
+			ins.Revision = w.s
+		case instanceCtime:
+			if t, err := time.ParseInLocation(boltTimeLayout, w.s, time.Local); err == nil {
+				ins.Ctime = t
+			}
+		}
+		return nil
+	})
+}
+
+func decodeHealthCheck(b []byte, ins *InstanceDetail) error {
+	return walkFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		switch num {
+		case healthCheckType:
+			ins.HealthCheckType = int(x)
+		case healthCheckHeartbeat:
+			return walkFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+				if num != heartbeatTTL {
+					return nil
+				}
+				w, err := decodeWrapper(v)
+				ins.HeartbeatTTL = int(w.x)
+				return err
+			})
+		}
+		return nil
+	})
+}
+
+func decodeLocation(b []byte, ins *InstanceDetail) error {
+	return walkFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		w, err := decodeWrapper(v)
+		if err != nil {
+			return err
+		}
+		switch num {
+		case locationRegion:
+			ins.Region = w.s
+		case locationZone:
+			ins.Zone = w.s
+		case locationCampus:
+			ins.Campus = w.s
+		}
+		return nil
+	})
+}
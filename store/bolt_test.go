@@ -0,0 +1,459 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 以北极星单机版的格式写入记录，编码独立于 bolt_codec.go 中的解码实现
+
+func boltString(s string) []byte {
+	return append([]byte{boltTypeString}, s...)
+}
+
+func boltBool(b bool) []byte {
+	if b {
+		return []byte{boltTypeBool, 1}
+	}
+	return []byte{boltTypeBool, 0}
+}
+
+func boltTime(t time.Time) []byte {
+	v := make([]byte, 9)
+	v[0] = boltTypeTime
+	binary.LittleEndian.PutUint64(v[1:], uint64(t.UnixNano()))
+	return v
+}
+
+func protoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func protoString(b []byte, num protowire.Number, s string) []byte {
+	return protoBytes(b, num, protoBytes(nil, wrapperValue, []byte(s)))
+}
+
+func protoVarint(b []byte, num protowire.Number, x uint64) []byte {
+	w := protowire.AppendTag(nil, wrapperValue, protowire.VarintType)
+	return protoBytes(b, num, protowire.AppendVarint(w, x))
+}
+
+// fixtureInstance 数据文件中的一个实例
+type fixtureInstance struct {
+	id        string
+	serviceID string
+	valid     bool
+	healthy   bool
+	check     bool
+	mtime     time.Time
+	metadata  map[string]string
+}
+
+func (ins fixtureInstance) proto() []byte {
+	b := protoString(nil, instanceID, ins.id)
+	b = protoString(b, instanceHost, "10.0.0.1")
+	b = protoVarint(b, instancePort, 8080)
+	b = protoVarint(b, instanceWeight, 100)
+	b = protoVarint(b, instanceHealthy, boolValue(ins.healthy))
+	b = protoVarint(b, instanceEnableHealthCheck, boolValue(ins.check))
+	if ins.check {
+		heartbeat := protoVarint(nil, heartbeatTTL, 5)
+		check := protowire.AppendTag(nil, healthCheckType, protowire.VarintType)
+		check = protowire.AppendVarint(check, 1)
+		b = protoBytes(b, instanceHealthCheck, protoBytes(check, healthCheckHeartbeat, heartbeat))
+	}
+	b = protoBytes(b, instanceLocation, protoString(nil, locationZone, "zone-a"))
+	keys := make([]string, 0, len(ins.metadata))
+	for k := range ins.metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := protowire.AppendTag(nil, mapEntryKey, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, mapEntryValue, protowire.BytesType)
+		entry = protowire.AppendString(entry, ins.metadata[k])
+		b = protoBytes(b, instanceMetadata, entry)
+	}
+	return b
+}
+
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fixtureService 数据文件中的一个服务
+type fixtureService struct {
+	id        string
+	name      string
+	namespace string
+	valid     bool
+	mtime     time.Time
+	metadata  map[string]string
+}
+
+// fixtureRule 数据文件中的一条治理规则
+type fixtureRule struct {
+	bucket    string
+	key       string
+	serviceID string
+	valid     bool
+	mtime     time.Time
+}
+
+func putRecord(tx *bolt.Tx, bucket, key string, fields map[string][]byte) (*bolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return nil, err
+	}
+	record, err := b.CreateBucket([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		if err := record.Put([]byte(k), v); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func writeFixture(t *testing.T, path string, services []fixtureService, instances []fixtureInstance,
+	rules []fixtureRule) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, svc := range services {
+			record, err := putRecord(tx, bucketService, svc.id, map[string][]byte{
+				fieldName:       boltString(svc.name),
+				fieldNamespace:  boltString(svc.namespace),
+				fieldOwner:      boltString("polaris"),
+				fieldValid:      boltBool(svc.valid),
+				fieldCreateTime: boltTime(svc.mtime.Add(-time.Hour)),
+				fieldModifyTime: boltTime(svc.mtime),
+			})
+			if err != nil {
+				return err
+			}
+			meta, err := record.CreateBucket([]byte(fieldMeta))
+			if err != nil {
+				return err
+			}
+			for k, v := range svc.metadata {
+				if err := meta.Put([]byte(k), []byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, ins := range instances {
+			_, err := putRecord(tx, bucketInstance, ins.id, map[string][]byte{
+				fieldProto:      append([]byte{boltTypeProtobuf}, ins.proto()...),
+				fieldServiceID:  boltString(ins.serviceID),
+				fieldValid:      boltBool(ins.valid),
+				fieldCreateTime: boltTime(ins.mtime.Add(-time.Hour)),
+				fieldModifyTime: boltTime(ins.mtime),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, rule := range rules {
+			fields := map[string][]byte{
+				fieldValid:      boltBool(rule.valid),
+				fieldModifyTime: boltTime(rule.mtime),
+			}
+			if rule.serviceID != "" {
+				fields[fieldServiceID] = boltString(rule.serviceID)
+			}
+			if _, err := putRecord(tx, rule.bucket, rule.key, fields); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dumpFile 读取数据文件中所有的 key 和取值，key 为以 / 连接的 bucket 路径
+func dumpFile(t *testing.T, path string) map[string]string {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	out := map[string]string{}
+	var walk func(prefix string, b *bolt.Bucket) error
+	walk = func(prefix string, b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			key := prefix + "/" + string(k)
+			if v == nil {
+				out[key] = ""
+				return walk(key, b.Bucket(k))
+			}
+			out[key] = string(v)
+			return nil
+		})
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			out[string(name)] = ""
+			return walk(string(name), b)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func instanceIDs(instances []*Instance) []string {
+	ids := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		ids = append(ids, ins.ID)
+	}
+	return ids
+}
+
+func TestBoltStoreFixture(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now()
+	services := []fixtureService{
+		{id: "svc-live", name: "live", namespace: "default", valid: true, mtime: old,
+			metadata: map[string]string{"team": "a", "env": "prod"}},
+		{id: "svc-deleted", name: "deleted", namespace: "default", mtime: old},
+		{id: "svc-deleted-in-use", name: "in-use", namespace: "other", mtime: old},
+		{id: "svc-deleted-recent", name: "recent", namespace: "default", mtime: recent},
+	}
+	instances := []fixtureInstance{
+		{id: "ins-healthy", serviceID: "svc-live", valid: true, healthy: true, check: true, mtime: old},
+		{id: "ins-in-use", serviceID: "svc-deleted-in-use", valid: true, healthy: true, mtime: old},
+		{id: "ins-invalid-1", serviceID: "svc-live", mtime: old},
+		{id: "ins-invalid-2", serviceID: "svc-live", mtime: old},
+		{id: "ins-invalid-recent", serviceID: "svc-live", mtime: recent},
+		{id: "ins-unhealthy", serviceID: "svc-live", valid: true, check: true, mtime: old,
+			metadata: map[string]string{"team": "b"}},
+		{id: "ins-unhealthy-no-check", serviceID: "svc-live", valid: true, mtime: old},
+	}
+	rules := []fixtureRule{
+		{bucket: "routing", key: "route-deleted", mtime: old},
+		{bucket: "routing", key: "route-live", valid: true, mtime: old},
+		{bucket: "routing", key: "route-recent", mtime: recent},
+		{bucket: "circuitbreaker_rule_relation", key: "rel-1", serviceID: "svc-gone", mtime: old},
+		{bucket: "circuitbreaker_rule_relation", key: "rel-2", serviceID: "svc-gone", mtime: old},
+		{bucket: "circuitbreaker_rule_relation", key: "rel-3", serviceID: "svc-live", valid: true, mtime: old},
+	}
+	path := filepath.Join(t.TempDir(), "polaris.bolt")
+	writeFixture(t, path, services, instances, rules)
+	before := dumpFile(t, path)
+
+	original, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBoltStore(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	t.Run("load instances", func(t *testing.T) {
+		tests := []struct {
+			name string
+			load func() ([]*Instance, error)
+			want []string
+		}{
+			{name: "invalid", want: []string{"ins-invalid-1", "ins-invalid-2"},
+				load: func() ([]*Instance, error) { return b.LoadInvalidInstancesAfter("", 60, 100) }},
+			{name: "invalid page", want: []string{"ins-invalid-1"},
+				load: func() ([]*Instance, error) { return b.LoadInvalidInstancesAfter("", 60, 1) }},
+			{name: "invalid after", want: []string{"ins-invalid-2"},
+				load: func() ([]*Instance, error) { return b.LoadInvalidInstancesAfter("ins-invalid-1", 60, 100) }},
+			{name: "unhealthy", want: []string{"ins-unhealthy"},
+				load: func() ([]*Instance, error) { return b.LoadUnhealthyInstancesAfter("", 60, 100) }},
+			{name: "namespace", want: []string{"ins-healthy", "ins-unhealthy", "ins-unhealthy-no-check"},
+				load: func() ([]*Instance, error) { return b.LoadNamespaceInstances("default", 60, 100) }},
+		}
+		for _, tt := range tests {
+			got, err := tt.load()
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if ids := instanceIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("%s = %v, want %v", tt.name, ids, tt.want)
+			}
+			for _, ins := range got {
+				if ins.Service != "live" || ins.Namespace != "default" || ins.Host != "10.0.0.1" || ins.Port != 8080 {
+					t.Errorf("%s: instance %+v, want live/default 10.0.0.1:8080", tt.name, ins)
+				}
+			}
+		}
+	})
+
+	t.Run("recheck", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			recheck func(ids []string) ([]string, error)
+			ids     []string
+			want    []string
+		}{
+			{name: "invalid instances", recheck: func(ids []string) ([]string, error) {
+				return b.RecheckInvalidInstances(ids, 60)
+			}, ids: []string{"ins-invalid-1", "ins-healthy", "ins-invalid-recent", "ins-missing"},
+				want: []string{"ins-invalid-1"}},
+			{name: "unhealthy instances", recheck: func(ids []string) ([]string, error) {
+				return b.RecheckUnhealthyInstances(ids, 60)
+			}, ids: []string{"ins-unhealthy", "ins-unhealthy-no-check", "ins-healthy"},
+				want: []string{"ins-unhealthy"}},
+			{name: "namespace instances", recheck: func(ids []string) ([]string, error) {
+				return b.RecheckNamespaceInstances("other", ids, 60)
+			}, ids: []string{"ins-in-use", "ins-healthy"}, want: []string{"ins-in-use"}},
+			{name: "invalid services", recheck: func(ids []string) ([]string, error) {
+				return b.RecheckInvalidServices(ids, 60)
+			}, ids: []string{"svc-deleted", "svc-deleted-in-use", "svc-deleted-recent", "svc-live"},
+				want: []string{"svc-deleted"}},
+		}
+		for _, tt := range tests {
+			got, err := tt.recheck(tt.ids)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recheck %s = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("load services and rules", func(t *testing.T) {
+		svcs, err := b.LoadAllInvalidServices(60, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(svcs) != 1 || svcs[0].ID != "svc-deleted" || svcs[0].Name != "deleted" {
+			t.Errorf("LoadAllInvalidServices = %+v, want only svc-deleted", svcs)
+		}
+		for kind, want := range map[string][]string{
+			"routing":                {"route-deleted"},
+			"circuitbreakerRelation": {"svc-gone"},
+			"ratelimit":              {},
+		} {
+			got, err := b.LoadAllInvalidRules(kind, 60, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("LoadAllInvalidRules(%s) = %v, want %v", kind, got, want)
+			}
+		}
+		kinds, err := b.ExistingRuleKinds()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(kinds)
+		if want := []string{"circuitbreakerRelation", "routing"}; !reflect.DeepEqual(kinds, want) {
+			t.Errorf("ExistingRuleKinds = %v, want %v", kinds, want)
+		}
+	})
+
+	t.Run("decode details", func(t *testing.T) {
+		details, err := b.LoadInstanceDetails([]string{"ins-unhealthy"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &InstanceDetail{ID: "ins-unhealthy", Service: "live", Namespace: "default", Host: "10.0.0.1",
+			Port: 8080, Weight: 100, EnableHealthCheck: true, HealthCheckType: 1, HeartbeatTTL: 5, Zone: "zone-a",
+			Metadata: map[string]string{"team": "b"}, Mtime: time.Unix(0, old.UnixNano())}
+		if len(details) != 1 || !reflect.DeepEqual(details[0], want) {
+			t.Errorf("LoadInstanceDetails = %+v, want %+v", details, want)
+		}
+		labels, err := b.LoadInstanceLabels([]string{"ins-unhealthy", "ins-healthy"}, []string{"team", "env"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantLabels := map[string]map[string]string{
+			"ins-unhealthy": {"team": "b", "env": "prod"},
+			"ins-healthy":   {"team": "a", "env": "prod"},
+		}
+		if !reflect.DeepEqual(labels, wantLabels) {
+			t.Errorf("LoadInstanceLabels = %v, want %v", labels, wantLabels)
+		}
+	})
+
+	// 有效的记录即使在删除列表中也不会被删除
+	if err := b.CleanInvalidInstanceList([]string{"ins-invalid-1", "ins-invalid-2", "ins-healthy"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CleanInvalidServiceList([]string{"svc-deleted", "svc-live"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CleanInvalidRuleList("routing", []string{"route-deleted", "route-live"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CleanInvalidRuleList("circuitbreakerRelation", []string{"svc-gone", "svc-live"}); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	deleted := []string{
+		"instance/ins-invalid-1", "instance/ins-invalid-2", "service/svc-deleted", "routing/route-deleted",
+		"circuitbreaker_rule_relation/rel-1", "circuitbreaker_rule_relation/rel-2",
+	}
+	want := map[string]string{}
+	for k, v := range before {
+		removed := false
+		for _, prefix := range deleted {
+			if k == prefix || strings.HasPrefix(k, prefix+"/") {
+				removed = true
+			}
+		}
+		if !removed {
+			want[k] = v
+		}
+	}
+	if got := dumpFile(t, path); !reflect.DeepEqual(got, want) {
+		t.Errorf("records after compaction differ\ngot:  %v\nwant: %v", got, want)
+	}
+	// 压缩后的数据写入新文件再替换原文件
+	if compacted, err := os.Stat(path); err != nil || os.SameFile(original, compacted) {
+		t.Errorf("data file is not compacted: %v", err)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("temporary compact file is left: %v", err)
+	}
+}
@@ -20,57 +20,92 @@ package store
 import (
 	"database/sql"
 	"errors"
-	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
-	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/metrics"
 )
 
-var (
-	s = new(PolarisDB)
-)
-
-func Initialize(cfg common.AppConfig) error {
-
-	dbSource := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", cfg.Store.DbUser, cfg.Store.DbPwd,
-		cfg.Store.DbHost, cfg.Store.DbPort, cfg.Store.DbName)
-	db, err := NewPolarisDB(dbSource)
-	if err != nil {
-		glog.Errorf("[ERROR] new polaris db err: %s", err.Error())
-	}
-
-	s = db
-
-	return nil
-}
-
-func GetStore() *PolarisDB {
-	return s
-}
-
 // PolarisDB 操作polaris数据库的工具类
 type PolarisDB struct {
 	db *sql.DB
+	// replicas 只读副本，查询待清理的资源时使用，删除前的复查和删除都在主库上执行
+	replicas []*sql.DB
+	next     uint32
 }
 
 func (p *PolarisDB) GetDB() *sql.DB {
 	return p.db
 }
 
+// Ping 检查数据库是否可以访问
+func (p *PolarisDB) Ping() error {
+	return p.db.Ping()
+}
+
 // Close 关闭数据库连接
 func (p *PolarisDB) Close() {
 	if p.db != nil {
 		_ = p.db.Close()
 	}
+	for _, replica := range p.replicas {
+		_ = replica.Close()
+	}
+}
+
+// query 执行查询，并记录监控指标
+func (p *PolarisDB) query(operation, str string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := p.db.Query(str, args...)
+	metrics.ObserveMysql(operation, start, err)
+	return rows, err
+}
+
+// queryReplica 轮流在只读副本上执行查询，没有配置副本或者副本查询失败时在主库上执行
+func (p *PolarisDB) queryReplica(operation, str string, args ...interface{}) (*sql.Rows, error) {
+	if len(p.replicas) == 0 {
+		return p.query(operation, str, args...)
+	}
+	i := int(atomic.AddUint32(&p.next, 1) % uint32(len(p.replicas)))
+	start := time.Now()
+	rows, err := p.replicas[i].Query(str, args...)
+	metrics.ObserveMysql(operation+"OnReplica", start, err)
+	if err == nil {
+		return rows, nil
+	}
+	glog.Warningf("[PolarisDB] %s on replica %d err: %s, retry on primary", operation, i, err.Error())
+	return p.query(operation, str, args...)
 }
 
-// NewPolarisDB 创建polaris数据库操作类
-func NewPolarisDB(source string) (*PolarisDB, error) {
+// exec 执行更新，并记录监控指标
+func (p *PolarisDB) exec(operation, str string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := p.db.Exec(str, args...)
+	metrics.ObserveMysql(operation, start, err)
+	return result, err
+}
+
+// NewPolarisDB 创建polaris数据库操作类，replicas 为只读副本的连接串，副本暂时不可用时查询会回退到主库
+func NewPolarisDB(source string, replicas ...string) (*PolarisDB, error) {
 	db, err := NewMysqlDB(source)
 	if err != nil {
 		return nil, err
 	}
-	return &PolarisDB{db: db}, nil
+	p := &PolarisDB{db: db}
+	for i, replicaSource := range replicas {
+		replica, err := sql.Open("mysql", replicaSource)
+		if err != nil {
+			p.Close()
+			glog.Errorf("open replica %d err: %s", i, err.Error())
+			return nil, err
+		}
+		if err := replica.Ping(); err != nil {
+			glog.Warningf("replica %d ping err: %s", i, err.Error())
+		}
+		p.replicas = append(p.replicas, replica)
+	}
+	return p, nil
 }
 
 // NewMysqlDB 创建MySQL操作类
@@ -93,37 +128,149 @@ func NewMysqlDB(dbSource string) (*sql.DB, error) {
 	return db, nil
 }
 
-// LoadAllInvalidInstances 加载所有失效的实例
-func (p *PolarisDB) LoadAllInvalidInstances(limitTime, limitNum int) ([]string, error) {
-	str := `select instance.id from instance where mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) and flag = 1 limit ?`
-	rows, err := p.db.Query(str, limitTime, limitNum)
+// Instance 待清理实例的基本信息
+type Instance struct {
+	ID        string
+	Service   string
+	Namespace string
+	Host      string
+	Port      int
+	// Age 实例最近一次变更距今的时间
+	Age time.Duration
+}
+
+// LoadInvalidInstancesAfter 按主键顺序加载 ID 大于 lastID 的一页失效实例
+func (p *PolarisDB) LoadInvalidInstancesAfter(lastID string, limitTime, limitNum int) ([]*Instance, error) {
+	str := `select instance.id, IFNULL(service.name, ''), IFNULL(service.namespace, ''), instance.host, ` +
+		`instance.port, TIMESTAMPDIFF(SECOND, instance.mtime, NOW()) from instance left join service on instance.service_id = service.id ` +
+		`where instance.id > ? and instance.mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) and instance.flag = 1 ` +
+		`order by instance.id limit ?`
+	rows, err := p.queryReplica("LoadInvalidInstancesAfter", str, lastID, limitTime, limitNum)
 	if err != nil {
 		glog.Errorf("[PolarisDB] load all invalid instances err: %s", err.Error())
 		return nil, err
 	}
 	defer rows.Close()
 
+	out, err := fetchInstanceRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	glog.Infof("[PolarisDB] get invalid instances after id(%s) count: %d", lastID, len(out))
+	return out, nil
+}
+
+// LoadUnhealthyInstancesAfter 按主键顺序加载 ID 大于 lastID 的一页长期不健康的实例
+func (p *PolarisDB) LoadUnhealthyInstancesAfter(lastID string, limitTime, limitNum int) ([]*Instance, error) {
+	str := `select instance.id, IFNULL(service.name, ''), IFNULL(service.namespace, ''), instance.host, ` +
+		`instance.port, TIMESTAMPDIFF(SECOND, instance.mtime, NOW()) from instance left join service on instance.service_id = service.id ` +
+		`where instance.id > ? and instance.flag = 0 and instance.enable_health_check = 1 and instance.health_status = 0 ` +
+		`and instance.mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) order by instance.id limit ?`
+	rows, err := p.queryReplica("LoadUnhealthyInstancesAfter", str, lastID, limitTime, limitNum)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load unhealthy instances err: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	out, err := fetchInstanceRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	glog.Infof("[PolarisDB] get unhealthy instances after id(%s) count: %d", lastID, len(out))
+	return out, nil
+}
+
+// LoadNamespaceInstances 加载命名空间下 limitTime 分钟内没有变更过的实例
+func (p *PolarisDB) LoadNamespaceInstances(namespace string, limitTime, limitNum int) ([]*Instance, error) {
+	str := `select instance.id, service.name, service.namespace, instance.host, instance.port, ` +
+		`TIMESTAMPDIFF(SECOND, instance.mtime, NOW()) from instance inner join service on instance.service_id = service.id ` +
+		`where instance.flag = 0 and service.namespace = ? ` +
+		`and instance.mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) limit ?`
+	rows, err := p.queryReplica("LoadNamespaceInstances", str, namespace, limitTime, limitNum)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load namespace(%s) instances err: %s", namespace, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	out, err := fetchInstanceRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	glog.Infof("[PolarisDB] get namespace(%s) instances count: %d", namespace, len(out))
+	return out, nil
+}
+
+// fetchInstanceRows 读取实例查询的结果
+func fetchInstanceRows(rows *sql.Rows) ([]*Instance, error) {
 	progress := 0
-	out := make([]string, 0)
+	out := make([]*Instance, 0)
 	for rows.Next() {
 		progress++
 		if progress%50000 == 0 {
 			glog.Infof("[PolarisDB] instance fetch rows progress: %d", progress)
 		}
-		var id string
-		err := rows.Scan(&id)
+		ins := &Instance{}
+		var age int64
+		err := rows.Scan(&ins.ID, &ins.Service, &ins.Namespace, &ins.Host, &ins.Port, &age)
 		if err != nil {
 			glog.Errorf("[PolarisDB] fetch instance rows err: %s", err.Error())
 			return nil, err
 		}
-		out = append(out, id)
+		ins.Age = time.Duration(age) * time.Second
+		out = append(out, ins)
 	}
 	if err := rows.Err(); err != nil {
 		glog.Errorf("[PolarisDB] instance rows catch err: %s", err.Error())
 		return nil, err
 	}
+	return out, nil
+}
+
+// ServiceKey 服务的唯一标识
+type ServiceKey struct {
+	Namespace string
+	Service   string
+}
+
+// InstanceCount 服务下未删除的实例数
+type InstanceCount struct {
+	Total int
+	// Healthy 健康且未隔离的实例数
+	Healthy int
+}
+
+// CountServiceInstances 统计每个服务下未删除的实例数
+func (p *PolarisDB) CountServiceInstances() (map[ServiceKey]InstanceCount, error) {
+	str := `select service.namespace, service.name, count(*), ` +
+		`IFNULL(sum(case when instance.health_status = 1 and instance.isolate = 0 then 1 else 0 end), 0) from instance ` +
+		`join service on instance.service_id = service.id where instance.flag = 0 ` +
+		`group by service.namespace, service.name`
+	rows, err := p.queryReplica("CountServiceInstances", str)
+	if err != nil {
+		glog.Errorf("[PolarisDB] count service instances err: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
 
-	glog.Infof("[PolarisDB] get all invalid instances count: %d", len(out))
+	out := make(map[ServiceKey]InstanceCount)
+	for rows.Next() {
+		var key ServiceKey
+		var count InstanceCount
+		if err := rows.Scan(&key.Namespace, &key.Service, &count.Total, &count.Healthy); err != nil {
+			glog.Errorf("[PolarisDB] fetch service instance count rows err: %s", err.Error())
+			return nil, err
+		}
+		out[key] = count
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] service instance count rows catch err: %s", err.Error())
+		return nil, err
+	}
 	return out, nil
 }
 
@@ -133,28 +280,117 @@ func (p *PolarisDB) CleanInvalidInstanceList(instanceIds []string) error {
 		return errors.New("missing id")
 	}
 
-	ids := make([]interface{}, 0, len(instanceIds))
+	paramStr, ids := placeholders(instanceIds)
+	str := "delete from instance where flag = 1 and id in " + paramStr
+	_, err := p.exec("CleanInvalidInstanceList", str, ids...)
+	if err != nil {
+		glog.Errorf("[PolarisDB] clean invalid instance(%s) err: %s", instanceIds, err.Error())
+		return err
+	}
+
+	glog.Info("[PolarisDB] clean invalid instance: ", instanceIds)
+
+	return nil
+}
+
+// Service 待清理服务的基本信息
+type Service struct {
+	ID        string
+	Name      string
+	Namespace string
+	// Age 服务最近一次变更距今的时间
+	Age time.Duration
+}
+
+// LoadAllInvalidServices 加载所有软删除的服务，仍有未删除实例的服务不会被加载
+func (p *PolarisDB) LoadAllInvalidServices(limitTime, limitNum int) ([]*Service, error) {
+	str := `select service.id, service.name, service.namespace, TIMESTAMPDIFF(SECOND, service.mtime, NOW()) ` +
+		`from service where service.mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) and service.flag = 1 ` +
+		`and not exists (select 1 from instance where instance.service_id = service.id and instance.flag = 0) ` +
+		`limit ?`
+	rows, err := p.queryReplica("LoadAllInvalidServices", str, limitTime, limitNum)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load all invalid services err: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*Service, 0)
+	for rows.Next() {
+		svc := &Service{}
+		var age int64
+		if err := rows.Scan(&svc.ID, &svc.Name, &svc.Namespace, &age); err != nil {
+			glog.Errorf("[PolarisDB] fetch service rows err: %s", err.Error())
+			return nil, err
+		}
+		svc.Age = time.Duration(age) * time.Second
+		out = append(out, svc)
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] service rows catch err: %s", err.Error())
+		return nil, err
+	}
+
+	glog.Infof("[PolarisDB] get all invalid services count: %d", len(out))
+	return out, nil
+}
+
+// CleanInvalidServiceList 清理软删除的服务列表，同时清理服务的元数据
+func (p *PolarisDB) CleanInvalidServiceList(serviceIds []string) (err error) {
+	if len(serviceIds) == 0 {
+		return errors.New("missing id")
+	}
+
+	start := time.Now()
+	defer func() {
+		metrics.ObserveMysql("CleanInvalidServiceList", start, err)
+	}()
+	paramStr, ids := placeholders(serviceIds)
+	tx, err := p.db.Begin()
+	if err != nil {
+		glog.Errorf("[PolarisDB] begin tx err: %s", err.Error())
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	metaStr := "delete from service_metadata where id in " +
+		"(select id from service where flag = 1 and id in " + paramStr + " and not exists " +
+		"(select 1 from instance where instance.service_id = service.id and instance.flag = 0))"
+	if _, err := tx.Exec(metaStr, ids...); err != nil {
+		glog.Errorf("[PolarisDB] clean invalid service(%s) metadata err: %s", serviceIds, err.Error())
+		return err
+	}
+	// 查询可能在只读副本上执行，删除时再次确认服务下没有未删除的实例
+	str := "delete from service where flag = 1 and id in " + paramStr +
+		" and not exists (select 1 from instance where instance.service_id = service.id and instance.flag = 0)"
+	if _, err := tx.Exec(str, ids...); err != nil {
+		glog.Errorf("[PolarisDB] clean invalid service(%s) err: %s", serviceIds, err.Error())
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		glog.Errorf("[PolarisDB] commit clean invalid service(%s) err: %s", serviceIds, err.Error())
+		return err
+	}
+
+	glog.Info("[PolarisDB] clean invalid service: ", serviceIds)
+
+	return nil
+}
+
+// placeholders 生成 in 语句的占位符以及对应的参数
+func placeholders(values []string) (string, []interface{}) {
+	args := make([]interface{}, 0, len(values))
 	var paramStr string
 	first := true
-	for _, insId := range instanceIds {
+	for _, v := range values {
 		if first {
 			paramStr = "(?"
 			first = false
 		} else {
 			paramStr += ", ?"
 		}
-		ids = append(ids, insId)
+		args = append(args, v)
 	}
 	paramStr += ")"
-
-	str := "delete from instance where flag = 1 and id in " + paramStr
-	_, err := p.db.Exec(str, ids...)
-	if err != nil {
-		glog.Errorf("[PolarisDB] clean invalid instance(%s) err: %s", instanceIds, err.Error())
-		return err
-	}
-
-	glog.Info("[PolarisDB] clean invalid instance: ", instanceIds)
-
-	return nil
+	return paramStr, args
 }
@@ -0,0 +1,215 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// InstanceDetail 实例的完整信息，用于删除前的审计以及恢复
+type InstanceDetail struct {
+	ID                string            `json:"id"`
+	Service           string            `json:"service"`
+	Namespace         string            `json:"namespace"`
+	VpcID             string            `json:"vpcId,omitempty"`
+	Host              string            `json:"host"`
+	Port              int               `json:"port"`
+	Protocol          string            `json:"protocol,omitempty"`
+	Version           string            `json:"version,omitempty"`
+	Healthy           bool              `json:"healthy"`
+	Isolate           bool              `json:"isolate"`
+	Weight            int               `json:"weight"`
+	EnableHealthCheck bool              `json:"enableHealthCheck"`
+	HealthCheckType   int               `json:"healthCheckType,omitempty"`
+	HeartbeatTTL      int               `json:"heartbeatTtl,omitempty"`
+	LogicSet          string            `json:"logicSet,omitempty"`
+	Region            string            `json:"region,omitempty"`
+	Zone              string            `json:"zone,omitempty"`
+	Campus            string            `json:"campus,omitempty"`
+	Priority          int               `json:"priority"`
+	Revision          string            `json:"revision,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	Flag              int               `json:"flag"`
+	Ctime             time.Time         `json:"ctime"`
+	Mtime             time.Time         `json:"mtime"`
+}
+
+// LoadInstanceDetails 加载实例的完整信息，包括健康检查配置和元数据
+func (p *PolarisDB) LoadInstanceDetails(instanceIds []string) ([]*InstanceDetail, error) {
+	if len(instanceIds) == 0 {
+		return nil, nil
+	}
+
+	paramStr, ids := placeholders(instanceIds)
+	str := `select instance.id, IFNULL(service.name, ''), IFNULL(service.namespace, ''), ` +
+		`IFNULL(instance.vpc_id, ''), instance.host, instance.port, IFNULL(instance.protocol, ''), ` +
+		`IFNULL(instance.version, ''), instance.health_status, instance.isolate, instance.weight, ` +
+		`instance.enable_health_check, IFNULL(instance.logic_set, ''), IFNULL(instance.cmdb_region, ''), ` +
+		`IFNULL(instance.cmdb_zone, ''), IFNULL(instance.cmdb_idc, ''), instance.priority, instance.revision, ` +
+		`instance.flag, UNIX_TIMESTAMP(instance.ctime), UNIX_TIMESTAMP(instance.mtime), ` +
+		`IFNULL(health_check.type, 0), IFNULL(health_check.ttl, 0) ` +
+		`from instance left join service on instance.service_id = service.id ` +
+		`left join health_check on instance.id = health_check.id where instance.id in ` + paramStr
+	rows, err := p.query("LoadInstanceDetails", str, ids...)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load instance details err: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*InstanceDetail, 0, len(instanceIds))
+	index := make(map[string]*InstanceDetail, len(instanceIds))
+	for rows.Next() {
+		var (
+			ins          = &InstanceDetail{}
+			ctime, mtime int64
+		)
+		err := rows.Scan(&ins.ID, &ins.Service, &ins.Namespace, &ins.VpcID, &ins.Host, &ins.Port,
+			&ins.Protocol, &ins.Version, &ins.Healthy, &ins.Isolate, &ins.Weight, &ins.EnableHealthCheck,
+			&ins.LogicSet, &ins.Region, &ins.Zone, &ins.Campus, &ins.Priority, &ins.Revision, &ins.Flag,
+			&ctime, &mtime, &ins.HealthCheckType, &ins.HeartbeatTTL)
+		if err != nil {
+			glog.Errorf("[PolarisDB] fetch instance detail rows err: %s", err.Error())
+			return nil, err
+		}
+		ins.Ctime = time.Unix(ctime, 0)
+		ins.Mtime = time.Unix(mtime, 0)
+		out = append(out, ins)
+		index[ins.ID] = ins
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] instance detail rows catch err: %s", err.Error())
+		return nil, err
+	}
+
+	metaStr := "select id, mkey, mvalue from instance_metadata where id in " + paramStr
+	metaRows, err := p.query("LoadInstanceDetails", metaStr, ids...)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load instance metadata err: %s", err.Error())
+		return nil, err
+	}
+	defer metaRows.Close()
+	for metaRows.Next() {
+		var id, key, value string
+		if err := metaRows.Scan(&id, &key, &value); err != nil {
+			glog.Errorf("[PolarisDB] fetch instance metadata rows err: %s", err.Error())
+			return nil, err
+		}
+		ins, ok := index[id]
+		if !ok {
+			continue
+		}
+		if ins.Metadata == nil {
+			ins.Metadata = map[string]string{}
+		}
+		ins.Metadata[key] = value
+	}
+	if err := metaRows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] instance metadata rows catch err: %s", err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// ServiceDetail 服务的完整信息，用于删除前的审计
+type ServiceDetail struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace"`
+	Ports      string            `json:"ports,omitempty"`
+	Business   string            `json:"business,omitempty"`
+	Department string            `json:"department,omitempty"`
+	Comment    string            `json:"comment,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
+	Revision   string            `json:"revision,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Flag       int               `json:"flag"`
+	Ctime      time.Time         `json:"ctime"`
+	Mtime      time.Time         `json:"mtime"`
+}
+
+// LoadServiceDetails 加载服务的完整信息，包括元数据
+func (p *PolarisDB) LoadServiceDetails(serviceIds []string) ([]*ServiceDetail, error) {
+	if len(serviceIds) == 0 {
+		return nil, nil
+	}
+
+	paramStr, ids := placeholders(serviceIds)
+	str := `select id, name, namespace, IFNULL(ports, ''), IFNULL(business, ''), IFNULL(department, ''), ` +
+		`IFNULL(comment, ''), owner, revision, flag, UNIX_TIMESTAMP(ctime), UNIX_TIMESTAMP(mtime) ` +
+		`from service where id in ` + paramStr
+	rows, err := p.query("LoadServiceDetails", str, ids...)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load service details err: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]*ServiceDetail, 0, len(serviceIds))
+	index := make(map[string]*ServiceDetail, len(serviceIds))
+	for rows.Next() {
+		var (
+			svc          = &ServiceDetail{}
+			ctime, mtime int64
+		)
+		err := rows.Scan(&svc.ID, &svc.Name, &svc.Namespace, &svc.Ports, &svc.Business, &svc.Department,
+			&svc.Comment, &svc.Owner, &svc.Revision, &svc.Flag, &ctime, &mtime)
+		if err != nil {
+			glog.Errorf("[PolarisDB] fetch service detail rows err: %s", err.Error())
+			return nil, err
+		}
+		svc.Ctime = time.Unix(ctime, 0)
+		svc.Mtime = time.Unix(mtime, 0)
+		out = append(out, svc)
+		index[svc.ID] = svc
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] service detail rows catch err: %s", err.Error())
+		return nil, err
+	}
+
+	metaStr := "select id, mkey, mvalue from service_metadata where id in " + paramStr
+	metaRows, err := p.query("LoadServiceDetails", metaStr, ids...)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load service metadata err: %s", err.Error())
+		return nil, err
+	}
+	defer metaRows.Close()
+	for metaRows.Next() {
+		var id, key, value string
+		if err := metaRows.Scan(&id, &key, &value); err != nil {
+			glog.Errorf("[PolarisDB] fetch service metadata rows err: %s", err.Error())
+			return nil, err
+		}
+		svc, ok := index[id]
+		if !ok {
+			continue
+		}
+		if svc.Metadata == nil {
+			svc.Metadata = map[string]string{}
+		}
+		svc.Metadata[key] = value
+	}
+	if err := metaRows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] service metadata rows catch err: %s", err.Error())
+		return nil, err
+	}
+	return out, nil
+}
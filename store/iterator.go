@@ -0,0 +1,115 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+)
+
+const (
+	// DefaultPageSize 分页查询待清理实例时默认每页的行数
+	DefaultPageSize = 500
+)
+
+// InstancePager 按主键顺序加载 ID 大于 lastID 的最多 limit 个实例
+type InstancePager func(lastID string, limit int) ([]*Instance, error)
+
+// InstanceIterator 按主键分页遍历待清理的实例，每次只加载一页，调用方处理完一页后再加载下一页；
+// 累计加载 deleteLimitedNum 个实例或者超过 maxRunTime 后结束
+type InstanceIterator struct {
+	pager    InstancePager
+	pageSize int
+	maxNum   int
+	deadline time.Time
+	lastID   string
+	scanned  int
+	// released 调用方归还的不计入 deleteLimitedNum 的实例数
+	released int
+	done     bool
+	timedOut bool
+}
+
+// NewInstanceIterator 根据清理参数创建实例分页遍历器，计时从创建时开始
+func NewInstanceIterator(pager InstancePager, cleanup common.Cleanup) *InstanceIterator {
+	it := &InstanceIterator{
+		pager:    pager,
+		pageSize: cleanup.PageSize,
+		maxNum:   cleanup.LimitedNum,
+	}
+	if it.pageSize <= 0 {
+		it.pageSize = DefaultPageSize
+	}
+	if cleanup.MaxRunTime > 0 {
+		it.deadline = time.Now().Add(time.Duration(cleanup.MaxRunTime) * time.Second)
+	}
+	return it
+}
+
+// Next 加载下一页实例，遍历结束时返回空列表
+func (it *InstanceIterator) Next() ([]*Instance, error) {
+	if it.done {
+		return nil, nil
+	}
+	limit := it.maxNum - it.scanned + it.released
+	if limit <= 0 {
+		glog.Infof("[InstanceIterator] stop after scanning %d instances, deleteLimitedNum is reached", it.scanned)
+		it.done = true
+		return nil, nil
+	}
+	if !it.deadline.IsZero() && time.Now().After(it.deadline) {
+		glog.Infof("[InstanceIterator] stop after scanning %d instances, maxRunTime is exhausted", it.scanned)
+		it.done = true
+		it.timedOut = true
+		return nil, nil
+	}
+	if limit > it.pageSize {
+		limit = it.pageSize
+	}
+
+	page, err := it.pager(it.lastID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(page) < limit {
+		it.done = true
+	}
+	if len(page) > 0 {
+		it.lastID = page[len(page)-1].ID
+	}
+	it.scanned += len(page)
+	return page, nil
+}
+
+// Release 已经加载的实例中有 n 个不计入 deleteLimitedNum，如按 ttl 标签放宽查询时间后加载的未过期实例，
+// 避免这些实例占满额度导致后面真正待清理的实例永远加载不到
+func (it *InstanceIterator) Release(n int) {
+	it.released += n
+}
+
+// TimedOut 遍历是否因为超过 maxRunTime 而提前结束
+func (it *InstanceIterator) TimedOut() bool {
+	return it.timedOut
+}
+
+// Scanned 已经加载的实例数
+func (it *InstanceIterator) Scanned() int {
+	return it.scanned
+}
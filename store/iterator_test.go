@@ -0,0 +1,156 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+)
+
+// pagerCall 记录一次分页查询的参数
+type pagerCall struct {
+	lastID string
+	limit  int
+}
+
+func TestInstanceIterator(t *testing.T) {
+	all := make([]*Instance, 0, 10)
+	for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		all = append(all, &Instance{ID: id})
+	}
+	tests := []struct {
+		name      string
+		instances int
+		cleanup   common.Cleanup
+		expired   bool
+		release   int
+		pageErr   error
+		wantPages [][]string
+		wantCalls []pagerCall
+		wantErr   bool
+	}{
+		{
+			name: "stop at deleteLimitedNum", instances: 10,
+			cleanup:   common.Cleanup{LimitedNum: 5, PageSize: 2},
+			wantPages: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+			wantCalls: []pagerCall{{"", 2}, {"b", 2}, {"d", 1}},
+		},
+		{
+			// 第一页都未超过 ttl，不计入 deleteLimitedNum
+			name: "released instances not counted", instances: 10, release: 2,
+			cleanup:   common.Cleanup{LimitedNum: 3, PageSize: 2},
+			wantPages: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+			wantCalls: []pagerCall{{"", 2}, {"b", 2}, {"d", 1}},
+		},
+		{
+			name: "stop after a short page", instances: 3,
+			cleanup:   common.Cleanup{LimitedNum: 100, PageSize: 2},
+			wantPages: [][]string{{"a", "b"}, {"c"}},
+			wantCalls: []pagerCall{{"", 2}, {"b", 2}},
+		},
+		{
+			name: "stop after an empty page", instances: 4,
+			cleanup:   common.Cleanup{LimitedNum: 100, PageSize: 2},
+			wantPages: [][]string{{"a", "b"}, {"c", "d"}},
+			wantCalls: []pagerCall{{"", 2}, {"b", 2}, {"d", 2}},
+		},
+		{
+			name: "default page size", instances: 3,
+			cleanup:   common.Cleanup{LimitedNum: 1000},
+			wantPages: [][]string{{"a", "b", "c"}},
+			wantCalls: []pagerCall{{"", DefaultPageSize}},
+		},
+		{
+			name: "maxRunTime exhausted", instances: 10,
+			cleanup: common.Cleanup{LimitedNum: 100, PageSize: 2, MaxRunTime: 1},
+			expired: true,
+		},
+		{
+			name: "page error", instances: 10,
+			cleanup:   common.Cleanup{LimitedNum: 100, PageSize: 2},
+			pageErr:   errors.New("query fail"),
+			wantCalls: []pagerCall{{"", 2}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []pagerCall
+			it := NewInstanceIterator(func(lastID string, limit int) ([]*Instance, error) {
+				calls = append(calls, pagerCall{lastID: lastID, limit: limit})
+				if tt.pageErr != nil {
+					return nil, tt.pageErr
+				}
+				var out []*Instance
+				for _, ins := range all[:tt.instances] {
+					if ins.ID > lastID && len(out) < limit {
+						out = append(out, ins)
+					}
+				}
+				return out, nil
+			}, tt.cleanup)
+			if tt.expired {
+				it.deadline = time.Now().Add(-time.Second)
+			}
+
+			var pages [][]string
+			scanned := 0
+			for i := 0; i < 10; i++ {
+				page, err := it.Next()
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Next() err = %v, want error %v", err, tt.wantErr)
+				}
+				if err != nil || len(page) == 0 {
+					break
+				}
+				ids := make([]string, 0, len(page))
+				for _, ins := range page {
+					ids = append(ids, ins.ID)
+				}
+				pages = append(pages, ids)
+				scanned += len(page)
+				if i == 0 {
+					it.Release(tt.release)
+				}
+			}
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("pager calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if it.Scanned() != scanned {
+				t.Errorf("Scanned() = %d, want %d", it.Scanned(), scanned)
+			}
+			if it.TimedOut() != tt.expired {
+				t.Errorf("TimedOut() = %v, want %v", it.TimedOut(), tt.expired)
+			}
+			if tt.wantErr {
+				return
+			}
+			// 遍历结束后不再查询
+			if page, _ := it.Next(); len(page) != 0 || len(calls) != len(tt.wantCalls) {
+				t.Errorf("Next() after the end = %v, pager calls %v", page, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// labelBatchSize 每次查询元数据的资源数量，避免 SQL 参数过多
+	labelBatchSize = 500
+)
+
+// LoadInstanceLabels 加载实例及其所属服务上指定 key 的元数据，同一个 key 以实例上的为准
+func (p *PolarisDB) LoadInstanceLabels(ids []string, keys []string) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string, len(ids))
+	serviceStr := `select instance.id, service_metadata.mkey, service_metadata.mvalue from instance ` +
+		`inner join service_metadata on instance.service_id = service_metadata.id ` +
+		`where instance.id in %s and service_metadata.mkey in %s`
+	if err := p.loadLabels("LoadInstanceServiceLabels", serviceStr, ids, keys, out); err != nil {
+		return nil, err
+	}
+	instanceStr := `select id, mkey, mvalue from instance_metadata where id in %s and mkey in %s`
+	if err := p.loadLabels("LoadInstanceLabels", instanceStr, ids, keys, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LoadServiceLabels 加载服务上指定 key 的元数据
+func (p *PolarisDB) LoadServiceLabels(ids []string, keys []string) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string, len(ids))
+	str := `select id, mkey, mvalue from service_metadata where id in %s and mkey in %s`
+	if err := p.loadLabels("LoadServiceLabels", str, ids, keys, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LoadLabelValues 加载服务和实例元数据中某个 key 的所有取值
+func (p *PolarisDB) LoadLabelValues(key string) ([]string, error) {
+	str := `select distinct mvalue from service_metadata where mkey = ? ` +
+		`union select distinct mvalue from instance_metadata where mkey = ?`
+	rows, err := p.query("LoadLabelValues", str, key, key)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load label(%s) values err: %s", key, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			glog.Errorf("[PolarisDB] fetch label value rows err: %s", err.Error())
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] label value rows catch err: %s", err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// loadLabels 分批查询元数据并写入 out，str 中的两个 %s 分别为资源 ID 和 key 的占位符
+func (p *PolarisDB) loadLabels(operation, str string, ids []string, keys []string,
+	out map[string]map[string]string) error {
+	keyStr, keyArgs := placeholders(keys)
+	for i := 0; i < len(ids); i += labelBatchSize {
+		j := i + labelBatchSize
+		if j > len(ids) {
+			j = len(ids)
+		}
+		idStr, args := placeholders(ids[i:j])
+		rows, err := p.query(operation, fmt.Sprintf(str, idStr, keyStr), append(args, keyArgs...)...)
+		if err != nil {
+			glog.Errorf("[PolarisDB] %s err: %s", operation, err.Error())
+			return err
+		}
+		err = fetchLabelRows(rows, out)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fetchLabelRows(rows *sql.Rows, out map[string]map[string]string) error {
+	for rows.Next() {
+		var id, key, value string
+		if err := rows.Scan(&id, &key, &value); err != nil {
+			glog.Errorf("[PolarisDB] fetch label rows err: %s", err.Error())
+			return err
+		}
+		if out[id] == nil {
+			out[id] = map[string]string{}
+		}
+		out[id][key] = value
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] label rows catch err: %s", err.Error())
+		return err
+	}
+	return nil
+}
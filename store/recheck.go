@@ -0,0 +1,80 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"github.com/golang/glog"
+)
+
+// RecheckInvalidInstances 在主库上复查失效的实例，返回仍然满足清理条件的实例 ID
+func (p *PolarisDB) RecheckInvalidInstances(instanceIds []string, limitTime int) ([]string, error) {
+	str := `select id from instance where flag = 1 and mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) and id in `
+	return p.recheck("RecheckInvalidInstances", str, instanceIds, limitTime)
+}
+
+// RecheckUnhealthyInstances 在主库上复查长期不健康的实例，返回仍然满足清理条件的实例 ID
+func (p *PolarisDB) RecheckUnhealthyInstances(instanceIds []string, limitTime int) ([]string, error) {
+	str := `select id from instance where flag = 0 and enable_health_check = 1 and health_status = 0 ` +
+		`and mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) and id in `
+	return p.recheck("RecheckUnhealthyInstances", str, instanceIds, limitTime)
+}
+
+// RecheckNamespaceInstances 在主库上复查命名空间下 limitTime 分钟内没有变更过的实例，返回仍然满足清理条件的实例 ID
+func (p *PolarisDB) RecheckNamespaceInstances(namespace string, instanceIds []string, limitTime int) ([]string, error) {
+	str := `select instance.id from instance inner join service on instance.service_id = service.id ` +
+		`where instance.flag = 0 and service.namespace = ? ` +
+		`and instance.mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) and instance.id in `
+	return p.recheck("RecheckNamespaceInstances", str, instanceIds, namespace, limitTime)
+}
+
+// RecheckInvalidServices 在主库上复查软删除的服务，返回仍然满足清理条件的服务 ID
+func (p *PolarisDB) RecheckInvalidServices(serviceIds []string, limitTime int) ([]string, error) {
+	str := `select id from service where flag = 1 and mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) ` +
+		`and not exists (select 1 from instance where instance.service_id = service.id and instance.flag = 0) ` +
+		`and id in `
+	return p.recheck("RecheckInvalidServices", str, serviceIds, limitTime)
+}
+
+// recheck 在主库上按 ID 查询仍然满足条件的资源，str 以 id in 结尾，args 为 ID 之前的参数
+func (p *PolarisDB) recheck(operation, str string, ids []string, args ...interface{}) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	paramStr, idArgs := placeholders(ids)
+	rows, err := p.query(operation, str+paramStr, append(args, idArgs...)...)
+	if err != nil {
+		glog.Errorf("[PolarisDB] %s err: %s", operation, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]string, 0, len(ids))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			glog.Errorf("[PolarisDB] fetch %s rows err: %s", operation, err.Error())
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] %s rows catch err: %s", operation, err.Error())
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,161 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// ruleTable 治理规则对应的数据表，bucket 和 idField 为北极星单机版中对应的 bucket 以及 ID 字段，
+// idField 为空时使用记录的 key
+type ruleTable struct {
+	table    string
+	idColumn string
+	bucket   string
+	idField  string
+}
+
+var (
+	ruleTables = map[string]ruleTable{
+		"routing":          {table: "routing_config", idColumn: "id", bucket: "routing"},
+		"routingV2":        {table: "routing_config_v2", idColumn: "id", bucket: "routing_config_v2"},
+		"ratelimit":        {table: "ratelimit_config", idColumn: "id", bucket: "ratelimit_config"},
+		"circuitbreaker":   {table: "circuitbreaker_rule", idColumn: "id", bucket: "circuitbreaker_rule"},
+		"circuitbreakerV2": {table: "circuitbreaker_rule_v2", idColumn: "id", bucket: "circuitbreaker_rule_v2"},
+		"faultDetect":      {table: "fault_detect_rule", idColumn: "id", bucket: "faultdetect_rule"},
+		"circuitbreakerRelation": {table: "circuitbreaker_rule_relation", idColumn: "service_id",
+			bucket: "circuitbreaker_rule_relation", idField: "serviceid"},
+	}
+)
+
+// RuleKinds 支持清理的治理规则类型
+func RuleKinds() []string {
+	kinds := make([]string, 0, len(ruleTables))
+	for kind := range ruleTables {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// filterRuleKinds 返回数据表满足 exists 的治理规则类型
+func filterRuleKinds(exists func(t ruleTable) bool) []string {
+	kinds := make([]string, 0, len(ruleTables))
+	for _, kind := range RuleKinds() {
+		if exists(ruleTables[kind]) {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+func getRuleTable(kind string) (ruleTable, error) {
+	t, ok := ruleTables[kind]
+	if !ok {
+		return ruleTable{}, fmt.Errorf("unknown rule kind: %s", kind)
+	}
+	return t, nil
+}
+
+// LoadAllInvalidRules 加载某类治理规则中所有软删除的规则
+func (p *PolarisDB) LoadAllInvalidRules(kind string, limitTime, limitNum int) ([]string, error) {
+	t, err := getRuleTable(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	str := fmt.Sprintf("select distinct %s from %s where mtime <= DATE_SUB(NOW(), INTERVAL ? MINUTE) "+
+		"and flag = 1 limit ?", t.idColumn, t.table)
+	rows, err := p.queryReplica("LoadAllInvalidRules", str, limitTime, limitNum)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load all invalid %s rules err: %s", kind, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			glog.Errorf("[PolarisDB] fetch %s rows err: %s", kind, err.Error())
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] %s rows catch err: %s", kind, err.Error())
+		return nil, err
+	}
+
+	glog.Infof("[PolarisDB] get all invalid %s rules count: %d", kind, len(out))
+	return out, nil
+}
+
+// CleanInvalidRuleList 清理某类治理规则中软删除的规则
+func (p *PolarisDB) CleanInvalidRuleList(kind string, ruleIds []string) error {
+	if len(ruleIds) == 0 {
+		return errors.New("missing id")
+	}
+	t, err := getRuleTable(kind)
+	if err != nil {
+		return err
+	}
+
+	paramStr, ids := placeholders(ruleIds)
+	str := fmt.Sprintf("delete from %s where flag = 1 and %s in %s", t.table, t.idColumn, paramStr)
+	if _, err := p.exec("CleanInvalidRuleList", str, ids...); err != nil {
+		glog.Errorf("[PolarisDB] clean invalid %s rules(%s) err: %s", kind, ruleIds, err.Error())
+		return err
+	}
+
+	glog.Infof("[PolarisDB] clean invalid %s rules: %v", kind, ruleIds)
+
+	return nil
+}
+
+// ExistingRuleKinds 返回当前数据库中有对应数据表的治理规则类型
+func (p *PolarisDB) ExistingRuleKinds() ([]string, error) {
+	str := "select table_name from information_schema.tables where table_schema = database()"
+	rows, err := p.query("ExistingRuleKinds", str)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load tables err: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := map[string]bool{}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			glog.Errorf("[PolarisDB] fetch table rows err: %s", err.Error())
+			return nil, err
+		}
+		tables[table] = true
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] table rows catch err: %s", err.Error())
+		return nil, err
+	}
+	return filterRuleKinds(func(t ruleTable) bool {
+		return tables[t.table]
+	}), nil
+}
@@ -0,0 +1,79 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// baseColumns 清理服务、实例以及记录审计日志依赖的表和字段
+var baseColumns = map[string][]string{
+	"service": {"id", "name", "namespace", "ports", "business", "department", "comment", "owner", "revision",
+		"flag", "ctime", "mtime"},
+	"instance": {"id", "service_id", "vpc_id", "host", "port", "protocol", "version", "health_status", "isolate",
+		"weight", "enable_health_check", "logic_set", "cmdb_region", "cmdb_zone", "cmdb_idc", "priority",
+		"revision", "flag", "ctime", "mtime"},
+	"health_check":      {"id", "type", "ttl"},
+	"service_metadata":  {"id", "mkey", "mvalue"},
+	"instance_metadata": {"id", "mkey", "mvalue"},
+}
+
+// RequiredColumns 返回清理依赖的表和字段，ruleKinds 为要清理的治理规则类型
+func RequiredColumns(ruleKinds []string) map[string][]string {
+	out := make(map[string][]string, len(baseColumns)+len(ruleKinds))
+	for table, columns := range baseColumns {
+		out[table] = columns
+	}
+	for _, kind := range ruleKinds {
+		if t, ok := ruleTables[kind]; ok {
+			out[t.table] = []string{t.idColumn, "flag", "mtime"}
+		}
+	}
+	return out
+}
+
+// LoadColumns 加载当前数据库中所有表的字段，表不存在时不会出现在结果中
+func (p *PolarisDB) LoadColumns() (map[string][]string, error) {
+	str := "select table_name, column_name from information_schema.columns where table_schema = database()"
+	rows, err := p.query("LoadColumns", str)
+	if err != nil {
+		glog.Errorf("[PolarisDB] load columns err: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string][]string{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			glog.Errorf("[PolarisDB] fetch column rows err: %s", err.Error())
+			return nil, err
+		}
+		out[table] = append(out[table], column)
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("[PolarisDB] column rows catch err: %s", err.Error())
+		return nil, err
+	}
+	for table := range out {
+		sort.Strings(out[table])
+	}
+	return out, nil
+}
@@ -0,0 +1,44 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"github.com/polarismesh/polaris-cleanup/common"
+)
+
+// SelectInstances 过滤出在清理范围内的实例
+func SelectInstances(m *common.Matcher, instances []*Instance) []*Instance {
+	out := make([]*Instance, 0, len(instances))
+	for _, ins := range instances {
+		if m.Match(ins.Namespace, ins.Service, ins.Host) {
+			out = append(out, ins)
+		}
+	}
+	return out
+}
+
+// SelectServices 过滤出在清理范围内的服务
+func SelectServices(m *common.Matcher, services []*Service) []*Service {
+	out := make([]*Service, 0, len(services))
+	for _, svc := range services {
+		if m.Match(svc.Namespace, svc.Name, "") {
+			out = append(out, svc)
+		}
+	}
+	return out
+}
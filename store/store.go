@@ -0,0 +1,131 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+)
+
+// Store 清理任务用到的北极星存储层操作，limitTime 的单位为分钟
+type Store interface {
+	// LoadInvalidInstancesAfter 按主键顺序加载 ID 大于 lastID 的一页失效实例
+	LoadInvalidInstancesAfter(lastID string, limitTime, limitNum int) ([]*Instance, error)
+	// LoadUnhealthyInstancesAfter 按主键顺序加载 ID 大于 lastID 的一页长期不健康的实例
+	LoadUnhealthyInstancesAfter(lastID string, limitTime, limitNum int) ([]*Instance, error)
+	// LoadNamespaceInstances 加载命名空间下 limitTime 分钟内没有变更过的实例
+	LoadNamespaceInstances(namespace string, limitTime, limitNum int) ([]*Instance, error)
+	// CountServiceInstances 统计每个服务下未删除的实例数，是全表的统计，每次任务执行只应查询一次
+	CountServiceInstances() (map[ServiceKey]InstanceCount, error)
+	// CleanInvalidInstanceList 按 ID 清理失效的实例
+	CleanInvalidInstanceList(instanceIds []string) error
+	// LoadAllInvalidServices 加载所有软删除的服务，仍有未删除实例的服务不会被加载
+	LoadAllInvalidServices(limitTime, limitNum int) ([]*Service, error)
+	// CleanInvalidServiceList 按 ID 清理软删除的服务，同时清理服务的元数据
+	CleanInvalidServiceList(serviceIds []string) error
+	// LoadAllInvalidRules 加载某类治理规则中所有软删除的规则
+	LoadAllInvalidRules(kind string, limitTime, limitNum int) ([]string, error)
+	// CleanInvalidRuleList 按 ID 清理某类治理规则中软删除的规则
+	CleanInvalidRuleList(kind string, ruleIds []string) error
+	// ExistingRuleKinds 返回当前存储中有对应数据表的治理规则类型，老版本北极星没有新增规则的表
+	ExistingRuleKinds() ([]string, error)
+	// RecheckInvalidInstances 删除前在主库上复查失效的实例，返回仍然满足清理条件的实例 ID
+	RecheckInvalidInstances(instanceIds []string, limitTime int) ([]string, error)
+	// RecheckUnhealthyInstances 删除前在主库上复查长期不健康的实例，返回仍然满足清理条件的实例 ID
+	RecheckUnhealthyInstances(instanceIds []string, limitTime int) ([]string, error)
+	// RecheckNamespaceInstances 删除前在主库上复查命名空间下的实例，返回仍然满足清理条件的实例 ID
+	RecheckNamespaceInstances(namespace string, instanceIds []string, limitTime int) ([]string, error)
+	// RecheckInvalidServices 删除前在主库上复查软删除的服务，返回仍然满足清理条件的服务 ID
+	RecheckInvalidServices(serviceIds []string, limitTime int) ([]string, error)
+	// LoadInstanceDetails 加载实例的完整信息，包括健康检查配置和元数据
+	LoadInstanceDetails(instanceIds []string) ([]*InstanceDetail, error)
+	// LoadServiceDetails 加载服务的完整信息，包括元数据
+	LoadServiceDetails(serviceIds []string) ([]*ServiceDetail, error)
+	// LoadInstanceLabels 加载实例及其所属服务上指定 key 的元数据，同一个 key 以实例上的为准
+	LoadInstanceLabels(ids []string, keys []string) (map[string]map[string]string, error)
+	// LoadServiceLabels 加载服务上指定 key 的元数据
+	LoadServiceLabels(ids []string, keys []string) (map[string]map[string]string, error)
+	// LoadLabelValues 加载服务和实例元数据中某个 key 的所有取值
+	LoadLabelValues(key string) ([]string, error)
+	// Ping 检查存储是否可以访问
+	Ping() error
+	// Close 关闭存储
+	Close()
+}
+
+var (
+	s Store
+)
+
+// Initialize 根据配置创建全局的存储
+func Initialize(cfg common.AppConfig) error {
+	db, err := NewStore(cfg.Store)
+	if err != nil {
+		glog.Errorf("[ERROR] new polaris store err: %s", err.Error())
+		return err
+	}
+
+	s = db
+
+	return nil
+}
+
+// GetStore 获取全局的存储，未初始化时返回 nil
+func GetStore() Store {
+	return s
+}
+
+// SetStore 替换全局的存储，用于在测试中注入存储的实现
+func SetStore(db Store) {
+	s = db
+}
+
+// Close 关闭全局的存储
+func Close() {
+	if s != nil {
+		s.Close()
+		s = nil
+	}
+}
+
+// NewStore 根据存储类型创建存储
+func NewStore(cfg common.Store) (Store, error) {
+	switch cfg.Type {
+	case "", common.StoreTypeMysql:
+		return NewPolarisDB(cfg.DataSource(), cfg.Replicas...)
+	case common.StoreTypeBoltDB:
+		return NewBoltStore(cfg.Path, cfg.Compact)
+	default:
+		return nil, fmt.Errorf("unknown store type: %s", cfg.Type)
+	}
+}
+
+// MysqlDB 获取全局存储的 MySQL 连接，用于依赖 MySQL 的审计日志和选主
+func MysqlDB() (*sql.DB, error) {
+	if s == nil {
+		return nil, fmt.Errorf("store is not initialized")
+	}
+	p, ok := s.(*PolarisDB)
+	if !ok || p.GetDB() == nil {
+		return nil, fmt.Errorf("store type is not %s", common.StoreTypeMysql)
+	}
+	return p.GetDB(), nil
+}
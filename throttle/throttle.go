@@ -0,0 +1,198 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package throttle
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/polarismesh/polaris-cleanup/common"
+	"github.com/polarismesh/polaris-cleanup/metrics"
+)
+
+const (
+	defaultBatchDeleteNum = 100
+	defaultSlowBatchTime  = time.Second
+	// maxFailures 连续失败多少批后不再分发新的批次
+	maxFailures = 3
+	// minRateRatio 降速后的速率不低于配置速率的 1/minRateRatio
+	minRateRatio = 10
+)
+
+// Throttle 控制一次任务执行中批量删除的并发数和速率。速率使用令牌桶限制，每删除一个资源消耗一个令牌；
+// 一批删除失败或者耗时超过 slowBatchTime 时速率减半，正常时逐步恢复到配置的速率
+type Throttle struct {
+	job         string
+	batchSize   int
+	concurrency int
+	slow        time.Duration
+	maxRate     float64
+	minRate     float64
+
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// New 根据清理参数创建删除限流器，一次任务执行中的多批删除应使用同一个限流器
+func New(job string, cleanup common.Cleanup) *Throttle {
+	t := &Throttle{
+		job:         job,
+		batchSize:   cleanup.BatchDeleteNum,
+		concurrency: cleanup.Concurrency,
+		slow:        time.Duration(cleanup.SlowBatchTime) * time.Millisecond,
+		maxRate:     float64(cleanup.DeleteRate),
+	}
+	if t.batchSize <= 0 {
+		t.batchSize = defaultBatchDeleteNum
+	}
+	if t.concurrency <= 0 {
+		t.concurrency = 1
+	}
+	if t.slow <= 0 {
+		t.slow = defaultSlowBatchTime
+	}
+	// 未配置速率时保持每秒删除一批
+	if t.maxRate <= 0 {
+		t.maxRate = float64(t.batchSize)
+	}
+	t.minRate = t.maxRate / minRateRatio
+	if t.minRate < 1 {
+		t.minRate = 1
+	}
+	t.rate = t.maxRate
+	t.tokens = t.maxRate
+	t.last = time.Now()
+	metrics.SetDeleteRate(job, t.rate)
+	return t
+}
+
+// Run 将 ids 按照 batchDeleteNum 分批，最多 concurrency 个批次同时执行 fn，每批执行前按当前速率获取令牌。
+// 连续 maxFailures 批失败后不再分发新的批次，等待执行中的批次结束后返回第一个错误
+func (t *Throttle) Run(ids []string, fn func(batch []string) error) error {
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		firstErr error
+		failures int
+	)
+	stopped := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return failures >= maxFailures
+	}
+
+	sem := make(chan struct{}, t.concurrency)
+	for i := 0; i < len(ids) && !stopped(); i += t.batchSize {
+		j := i + t.batchSize
+		if j > len(ids) {
+			j = len(ids)
+		}
+		batch := ids[i:j]
+		sem <- struct{}{}
+		// 等待空闲协程期间可能有批次失败
+		if stopped() {
+			<-sem
+			break
+		}
+		t.wait(len(batch))
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			start := time.Now()
+			err := fn(batch)
+			t.observe(time.Since(start), err)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err == nil {
+				failures = 0
+				return
+			}
+			failures++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+	if failures >= maxFailures {
+		return fmt.Errorf("stop deleting after %d consecutive failed batches, first error: %v", failures, firstErr)
+	}
+	return firstErr
+}
+
+// wait 获取 n 个令牌，令牌不足时先预支，再等待补足预支的令牌
+func (t *Throttle) wait(n int) {
+	t.lock.Lock()
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	t.last = now
+	// 桶的容量为一秒的速率，至少能容纳一批
+	capacity := t.rate
+	if capacity < float64(n) {
+		capacity = float64(n)
+	}
+	if t.tokens > capacity {
+		t.tokens = capacity
+	}
+	t.tokens -= float64(n)
+	var delay time.Duration
+	if t.tokens < 0 {
+		delay = time.Duration(-t.tokens / t.rate * float64(time.Second))
+	}
+	t.lock.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// observe 根据一批删除的耗时和结果调整速率，失败或者过慢时减半，正常时增加配置速率的 1/minRateRatio
+func (t *Throttle) observe(cost time.Duration, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	rate := t.rate
+	if err != nil || cost > t.slow {
+		if rate /= 2; rate < t.minRate {
+			rate = t.minRate
+		}
+	} else if rate < t.maxRate {
+		if rate += t.maxRate / minRateRatio; rate > t.maxRate {
+			rate = t.maxRate
+		}
+	}
+	if rate == t.rate {
+		return
+	}
+	if rate < t.rate {
+		glog.Warningf("[Throttle][%s] slow down to %.1f deletes per second, batch cost %v, err %v",
+			t.job, rate, cost, err)
+	} else {
+		glog.Infof("[Throttle][%s] speed up to %.1f deletes per second", t.job, rate)
+	}
+	t.rate = rate
+	metrics.SetDeleteRate(t.job, rate)
+}
@@ -0,0 +1,194 @@
+/**
+ * Tencent is pleased to support the open source community by making Polaris available.
+ *
+ * Copyright (C) 2019 THL A29 Limited, a Tencent company. All rights reserved.
+ *
+ * Licensed under the BSD 3-Clause License (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * https://opensource.org/licenses/BSD-3-Clause
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed
+ * under the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR
+ * CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+
+package throttle
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/polarismesh/polaris-cleanup/common"
+)
+
+func makeIDs(n int) []string {
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ids = append(ids, string(rune('a'+i)))
+	}
+	return ids
+}
+
+func TestRunFailures(t *testing.T) {
+	errFail := errors.New("delete fail")
+	tests := []struct {
+		name      string
+		ids       int
+		fail      func(call int) bool
+		wantCalls int
+		wantErr   string
+	}{
+		{name: "all succeed", ids: 10, fail: func(int) bool { return false }, wantCalls: 10},
+		{name: "stop after maxFailures", ids: 10, fail: func(int) bool { return true }, wantCalls: maxFailures,
+			wantErr: "stop deleting after 3 consecutive failed batches"},
+		{name: "stop after maxFailures in the middle", ids: 10, fail: func(call int) bool { return call >= 4 },
+			wantCalls: 4 + maxFailures, wantErr: "stop deleting after 3 consecutive failed batches"},
+		// 成功的批次清零连续失败的计数，所有批次都会执行，返回第一个错误
+		{name: "failures not consecutive", ids: 10, fail: func(call int) bool { return call%2 == 0 }, wantCalls: 10,
+			wantErr: errFail.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := New("test", common.Cleanup{BatchDeleteNum: 1, DeleteRate: 10000})
+			calls := 0
+			err := th.Run(makeIDs(tt.ids), func(batch []string) error {
+				call := calls
+				calls++
+				if tt.fail(call) {
+					return errFail
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, tt.wantCalls)
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("err = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunConcurrency(t *testing.T) {
+	tests := []struct {
+		concurrency int
+		want        int
+	}{
+		{concurrency: 0, want: 1},
+		{concurrency: 1, want: 1},
+		{concurrency: 3, want: 3},
+	}
+	for _, tt := range tests {
+		th := New("test", common.Cleanup{BatchDeleteNum: 2, Concurrency: tt.concurrency, DeleteRate: 10000})
+		var (
+			lock     sync.Mutex
+			running  int
+			max      int
+			received []string
+		)
+		err := th.Run(makeIDs(12), func(batch []string) error {
+			lock.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			received = append(received, batch...)
+			lock.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if max != tt.want {
+			t.Errorf("concurrency %d: %d batches ran at the same time, want %d", tt.concurrency, max, tt.want)
+		}
+		if len(received) != 12 {
+			t.Errorf("concurrency %d: %d ids deleted, want 12", tt.concurrency, len(received))
+		}
+	}
+}
+
+func TestObserveRate(t *testing.T) {
+	errFail := errors.New("delete fail")
+	type step struct {
+		cost time.Duration
+		err  error
+		want float64
+	}
+	// 配置速率为 100，最低为 10，过慢的阈值为 100ms
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "fast batches keep the rate", steps: []step{
+			{cost: time.Millisecond, want: 100},
+			{cost: time.Millisecond, want: 100},
+		}},
+		{name: "slow down then recover", steps: []step{
+			{cost: time.Second, want: 50},
+			{cost: time.Millisecond, err: errFail, want: 25},
+			{cost: time.Millisecond, want: 35},
+			{cost: time.Millisecond, want: 45},
+			{cost: time.Second, want: 22.5},
+			{cost: time.Millisecond, want: 32.5},
+		}},
+		{name: "recover up to the configured rate", steps: []step{
+			{cost: time.Second, want: 50},
+			{cost: time.Millisecond, want: 60},
+			{cost: time.Millisecond, want: 70},
+			{cost: time.Millisecond, want: 80},
+			{cost: time.Millisecond, want: 90},
+			{cost: time.Millisecond, want: 100},
+			{cost: time.Millisecond, want: 100},
+		}},
+		{name: "not below the minimum rate", steps: []step{
+			{err: errFail, want: 50},
+			{err: errFail, want: 25},
+			{err: errFail, want: 12.5},
+			{err: errFail, want: 10},
+			{err: errFail, want: 10},
+			{cost: time.Millisecond, want: 20},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := New("test", common.Cleanup{BatchDeleteNum: 10, DeleteRate: 100, SlowBatchTime: 100})
+			for i, s := range tt.steps {
+				th.observe(s.cost, s.err)
+				if th.rate != s.want {
+					t.Fatalf("step %d: rate = %v, want %v", i, th.rate, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunSlowsDown(t *testing.T) {
+	th := New("test", common.Cleanup{BatchDeleteNum: 1, DeleteRate: 1000, SlowBatchTime: 10})
+	err := th.Run(makeIDs(2), func(batch []string) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两批都超过 slowBatchTime，速率减半两次
+	if th.rate != 250 {
+		t.Errorf("rate = %v after slow batches, want 250", th.rate)
+	}
+}